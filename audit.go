@@ -0,0 +1,275 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AuditKind is the type of a security audit event.
+type AuditKind string
+
+const (
+	AuditLogin        AuditKind = "login"         // see Login
+	AuditLogout       AuditKind = "logout"        // see Logout
+	AuditRevocation   AuditKind = "revocation"    // a token has been revoked (see Revoke)
+	AuditTampered     AuditKind = "tampered"      // tampering attempt: AEAD failure or bad magic code
+	AuditIPMismatch   AuditKind = "ip_mismatch"   // token presented from another IP (see SetIP)
+	AuditRevokedToken AuditKind = "revoked_token" // revoked token presented
+	AuditExpired      AuditKind = "expired"       // plain expiry, not a tampering attempt
+)
+
+// AuditEvent is a security event recorded by the AuditSink.
+// The request fields are empty when the event is not related to a request (e.g. Revoke).
+type AuditEvent struct {
+	Time    time.Time `json:"time"`
+	Kind    AuditKind `json:"kind"`
+	Reason  Reason    `json:"reason,omitempty"`
+	Source  string    `json:"source,omitempty"` // token extractor: "cookie", "bearer"…
+	Method  string    `json:"method,omitempty"`
+	Route   string    `json:"route,omitempty"`
+	IP      string    `json:"ip,omitempty"`       // client IP (remote address of the request)
+	TokenID string    `json:"token_id,omitempty"` // see WithTokenID
+	Detail  string    `json:"detail,omitempty"`
+}
+
+// AuditSink records the security audit events.
+// Audit is called by the request goroutines: it must be safe for concurrent use
+// and should not block (see AsyncSink).
+type AuditSink interface {
+	Audit(ev AuditEvent)
+}
+
+// WithAuditSink sets the sink recording the security audit events (default is none).
+// The rejected tokens are audited by Set, Chk, Vet and the Decode*Token functions,
+// the missing and malformed tokens are not audited.
+func WithAuditSink(sink AuditSink) Option {
+	return func(incorr *Incorruptible) {
+		incorr.audit = sink
+	}
+}
+
+// Login regenerates the token (see Regenerate) and records an AuditLogin event.
+func (incorr *Incorruptible) Login(w http.ResponseWriter, r *http.Request, mutate func(*TValues)) (TValues, error) {
+	tv, err := incorr.Regenerate(w, r, mutate)
+	if err != nil {
+		return tv, err
+	}
+	incorr.auditEvent(r, AuditLogin, tv)
+	return tv, nil
+}
+
+// Logout deletes the cookie, revokes the current token (see WithRevocationStore),
+// deletes its spilled values (see WithSessionStore) and records an AuditLogout event.
+func (incorr *Incorruptible) Logout(w http.ResponseWriter, r *http.Request) {
+	tv, ok := currentValues(r)
+	if !ok {
+		var a []any
+		tv, a = incorr.DecodeToken(r)
+		ok = (a == nil)
+	}
+
+	for _, dead := range incorr.DeadCookies(r) {
+		http.SetCookie(w, dead)
+	}
+
+	if ok {
+		incorr.retire(tv)
+	}
+	incorr.auditEvent(r, AuditLogout, tv)
+}
+
+// auditRejection records the rejection of a token when its Reason is a security concern.
+func (incorr *Incorruptible) auditRejection(r *http.Request, source string, tv TValues, err error) {
+	if incorr.audit == nil {
+		return
+	}
+
+	reason := ReasonOf(err)
+	var kind AuditKind
+	switch reason {
+	case ReasonTampered:
+		kind = AuditTampered
+	case ReasonIPMismatch:
+		kind = AuditIPMismatch
+	case ReasonRevoked:
+		kind = AuditRevokedToken
+	case ReasonExpired:
+		kind = AuditExpired
+	default:
+		return
+	}
+
+	ev := incorr.newAuditEvent(r, kind, tv, err.Error())
+	ev.Reason = reason
+	ev.Source = source
+	incorr.audit.Audit(ev)
+}
+
+func (incorr *Incorruptible) auditEvent(r *http.Request, kind AuditKind, tv TValues) {
+	if incorr.audit != nil {
+		incorr.audit.Audit(incorr.newAuditEvent(r, kind, tv, ""))
+	}
+}
+
+func (incorr *Incorruptible) newAuditEvent(r *http.Request, kind AuditKind, tv TValues, detail string) AuditEvent {
+	ev := AuditEvent{
+		Time:   time.Now().UTC(),
+		Kind:   kind,
+		Detail: detail,
+	}
+	ev.TokenID, _ = incorr.tokenID(tv)
+	if r != nil {
+		ev.Method = r.Method
+		ev.Route = r.URL.Path
+		if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			ev.IP = ip
+		}
+	}
+	return ev
+}
+
+// JSONLinesSink is an AuditSink writing one JSON object per line.
+// The write errors are counted (see Failed and Err): Audit never fails the request.
+type JSONLinesSink struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	c      io.Closer
+	err    error
+	failed atomic.Uint64
+}
+
+// NewJSONLinesSink writes the audit events to w.
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{enc: json.NewEncoder(w)}
+}
+
+// OpenJSONLinesSink appends the audit events to the file (created if needed).
+func OpenJSONLinesSink(path string) (*JSONLinesSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	sink := NewJSONLinesSink(f)
+	sink.c = f
+	return sink, nil
+}
+
+func (s *JSONLinesSink) Audit(ev AuditEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(ev); err != nil {
+		s.err = err
+		s.failed.Add(1)
+	}
+}
+
+// Failed returns the number of events that could not be written.
+func (s *JSONLinesSink) Failed() uint64 {
+	return s.failed.Load()
+}
+
+// Err returns the last write error, nil if none.
+func (s *JSONLinesSink) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close closes the file opened by OpenJSONLinesSink.
+func (s *JSONLinesSink) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	return s.c.Close()
+}
+
+var (
+	// ErrSinkClosed is returned when closing an AsyncSink twice.
+	ErrSinkClosed = errors.New("audit sink already closed")
+	// ErrSinkSize is returned by NewAsyncSink when the buffer size is not positive.
+	ErrSinkSize = errors.New("audit sink requires a positive buffer size")
+)
+
+// AsyncSink is an AuditSink forwarding the events to another sink from a background goroutine.
+// AsyncSink never blocks the request path: the events are dropped when the buffer is full
+// (see Dropped).
+type AsyncSink struct {
+	next    AuditSink
+	events  chan AuditEvent
+	done    chan struct{}
+	mu      sync.RWMutex
+	closed  bool
+	dropped atomic.Uint64
+}
+
+// NewAsyncSink starts the goroutine forwarding the events to next.
+// size is the number of buffered events. Call Close to flush the buffered events.
+// The returned error wraps ErrSinkSize when size is not positive.
+func NewAsyncSink(next AuditSink, size int) (*AsyncSink, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("%w: got %d", ErrSinkSize, size)
+	}
+	s := &AsyncSink{
+		next:   next,
+		events: make(chan AuditEvent, size),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *AsyncSink) run() {
+	for ev := range s.events {
+		s.next.Audit(ev)
+	}
+	close(s.done)
+}
+
+func (s *AsyncSink) Audit(ev AuditEvent) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		s.dropped.Add(1)
+		return
+	}
+
+	select {
+	case s.events <- ev:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of events dropped because the buffer was full or the sink closed.
+func (s *AsyncSink) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close flushes the buffered events and stops the goroutine.
+func (s *AsyncSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrSinkClosed
+	}
+	s.closed = true
+	close(s.events)
+	s.mu.Unlock()
+
+	<-s.done
+	return nil
+}
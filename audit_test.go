@@ -0,0 +1,220 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+type memorySink struct {
+	mu     sync.Mutex
+	events []incorruptible.AuditEvent
+}
+
+func (s *memorySink) Audit(ev incorruptible.AuditEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, ev)
+}
+
+func (s *memorySink) kinds() []incorruptible.AuditKind {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kinds := make([]incorruptible.AuditKind, 0, len(s.events))
+	for _, ev := range s.events {
+		kinds = append(kinds, ev.Kind)
+	}
+	return kinds
+}
+
+func TestAudit_Rejections(t *testing.T) {
+	t.Parallel()
+
+	sink := &memorySink{}
+	incorr := newTestIncorr(t, "https://example.com", incorruptible.WithAuditSink(sink))
+	handler := incorr.Chk(okHandler)
+
+	var expired incorruptible.TValues
+	expired.Expires = time.Now().Add(-time.Hour).Unix()
+	token, err := incorr.Encode(expired)
+	if err != nil {
+		t.Fatal("Encode() error", err)
+	}
+	valid, err := incorr.Encode(incorruptible.EmptyTValues())
+	if err != nil {
+		t.Fatal("Encode() error", err)
+	}
+
+	cases := []struct {
+		name  string
+		value string
+		want  []incorruptible.AuditKind
+	}{
+		{"expired", token, []incorruptible.AuditKind{incorruptible.AuditExpired}},
+		{"tampered", valid[:len(valid)-2] + "AA", []incorruptible.AuditKind{incorruptible.AuditTampered}},
+		{"garbage is not audited", "", nil},
+	}
+
+	for _, c := range cases {
+		sink.events = nil
+
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/api", http.NoBody)
+		if c.value != "" {
+			r.Header.Set("Authorization", "Bearer "+incorruptible.DefaultTokenScheme+c.value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", c.name, w.Code)
+		}
+		got := sink.kinds()
+		if len(got) != len(c.want) || (len(got) > 0 && got[0] != c.want[0]) {
+			t.Errorf("%s: audit kinds %v, want %v", c.name, got, c.want)
+			continue
+		}
+		if len(got) > 0 {
+			ev := sink.events[0]
+			if ev.Source != "bearer" || ev.Route != "/api" || ev.IP == "" || ev.Time.IsZero() {
+				t.Errorf("%s: incomplete audit event %+v", c.name, ev)
+			}
+		}
+	}
+}
+
+func TestAudit_LoginLogout(t *testing.T) {
+	t.Parallel()
+
+	sink := &memorySink{}
+	incorr := newTestIncorr(t, "https://example.com",
+		incorruptible.WithTokenID(1),
+		incorruptible.WithRevocationStore(incorruptible.NewMemoryStore()),
+		incorruptible.WithAuditSink(sink))
+
+	r := httptest.NewRequest(http.MethodPost, "https://example.com/login", http.NoBody)
+	w := httptest.NewRecorder()
+	_, err := incorr.Login(w, r, nil)
+	if err != nil {
+		t.Fatal("Login() error", err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Login() set %d cookies, want 1", len(cookies))
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "https://example.com/logout", http.NoBody)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	incorr.Logout(w, r)
+
+	if c := w.Result().Cookies(); len(c) == 0 || c[0].MaxAge >= 0 {
+		t.Errorf("Logout() must delete the cookie, got %v", c)
+	}
+
+	want := []incorruptible.AuditKind{incorruptible.AuditLogin, incorruptible.AuditRevocation, incorruptible.AuditLogout}
+	got := sink.kinds()
+	if len(got) != len(want) {
+		t.Fatalf("audit kinds %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("audit kinds %v, want %v", got, want)
+		}
+		if sink.events[i].TokenID == "" || sink.events[i].TokenID != sink.events[0].TokenID {
+			t.Errorf("%s: token ID %q, want %q", got[i], sink.events[i].TokenID, sink.events[0].TokenID)
+		}
+	}
+
+	// the revoked token is rejected and audited
+	sink.events = nil
+	r = httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	r.AddCookie(cookies[0])
+	if _, err = incorr.DecodeCookieToken(r); err == nil {
+		t.Error("DecodeCookieToken() must reject the revoked token")
+	}
+	if got = sink.kinds(); len(got) != 1 || got[0] != incorruptible.AuditRevokedToken {
+		t.Errorf("audit kinds %v, want [revoked_token]", got)
+	}
+}
+
+type blockingSink struct{ release chan struct{} }
+
+func (s blockingSink) Audit(incorruptible.AuditEvent) { <-s.release }
+
+func TestAsyncSink(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	async, err := incorruptible.NewAsyncSink(incorruptible.NewJSONLinesSink(&buf), 8)
+	if err != nil {
+		t.Fatal("NewAsyncSink() error", err)
+	}
+	for _, k := range []incorruptible.AuditKind{incorruptible.AuditLogin, incorruptible.AuditLogout} {
+		async.Audit(incorruptible.AuditEvent{Kind: k})
+	}
+	if err := async.Close(); err != nil {
+		t.Fatal("Close() error", err)
+	}
+	if err := async.Close(); err == nil {
+		t.Error("second Close() must fail")
+	}
+
+	dec := json.NewDecoder(&buf)
+	var n int
+	for ; dec.More(); n++ {
+		var ev incorruptible.AuditEvent
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatal("json.Decode() error", err)
+		}
+	}
+	if n != 2 || async.Dropped() != 0 {
+		t.Errorf("got %d lines and %d dropped, want 2 and 0", n, async.Dropped())
+	}
+
+	// full buffer => drop instead of blocking
+	blocking := blockingSink{release: make(chan struct{})}
+	async, err = incorruptible.NewAsyncSink(blocking, 1)
+	if err != nil {
+		t.Fatal("NewAsyncSink() error", err)
+	}
+	for range 5 {
+		async.Audit(incorruptible.AuditEvent{Kind: incorruptible.AuditTampered})
+	}
+	if async.Dropped() < 3 {
+		t.Errorf("dropped %d events, want at least 3", async.Dropped())
+	}
+	close(blocking.release)
+	_ = async.Close()
+
+	if _, err = incorruptible.NewAsyncSink(blocking, 0); !errors.Is(err, incorruptible.ErrSinkSize) {
+		t.Errorf("NewAsyncSink(0) error %v, want ErrSinkSize", err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestJSONLinesSink_Failed(t *testing.T) {
+	t.Parallel()
+
+	sink := incorruptible.NewJSONLinesSink(failingWriter{})
+	sink.Audit(incorruptible.AuditEvent{Kind: incorruptible.AuditLogin})
+	sink.Audit(incorruptible.AuditEvent{Kind: incorruptible.AuditLogout})
+
+	if sink.Failed() != 2 || sink.Err() == nil {
+		t.Errorf("Failed()=%d Err()=%v, want 2 and an error", sink.Failed(), sink.Err())
+	}
+}
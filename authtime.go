@@ -0,0 +1,124 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// WithAuthTimeKey sets the key storing the time of the last user authentication
+// (Unix seconds, as the "auth_time" claim of OpenID Connect).
+// This enables SetAuthTime, RefreshAuthTime and RequireRecentAuth.
+func WithAuthTimeKey(key int) Option {
+	return func(incorr *Incorruptible) {
+		if err := checkWrite(key); err != nil {
+			log.Panic("WithAuthTimeKey", err)
+		}
+		incorr.authTimeKey = key
+	}
+}
+
+// errNoAuthTimeKey is returned when WithAuthTimeKey is not used.
+var errNoAuthTimeKey = errors.New("auth-time requires the option WithAuthTimeKey")
+
+// SetAuthTime stores the authentication time in the token values.
+// Call SetAuthTime at login, before issuing the cookie.
+func (incorr *Incorruptible) SetAuthTime(tv *TValues, t time.Time) error {
+	if incorr.authTimeKey < 0 {
+		return errNoAuthTimeKey
+	}
+	return tv.SetInt64(incorr.authTimeKey, t.Unix())
+}
+
+// AuthTime returns the authentication time stored in the token values.
+func (incorr *Incorruptible) AuthTime(tv TValues) (time.Time, error) {
+	if incorr.authTimeKey < 0 {
+		return time.Time{}, errNoAuthTimeKey
+	}
+	sec, err := tv.Int64(incorr.authTimeKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if sec <= 0 {
+		return time.Time{}, errors.New("no auth-time")
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// RefreshAuthTime sets the authentication time to now, after a successful re-authentication.
+// The other token values are kept, the cookie is re-issued by the Set or Chk middleware (see Session).
+func (incorr *Incorruptible) RefreshAuthTime(r *http.Request) error {
+	if incorr.authTimeKey < 0 {
+		return errNoAuthTimeKey
+	}
+	s, ok := SessionFromCtx(r)
+	if !ok {
+		return errors.New("RefreshAuthTime requires the Set or Chk middleware")
+	}
+	return s.Update(func(tv *TValues) error {
+		return incorr.SetAuthTime(tv, time.Now())
+	})
+}
+
+// RequireRecentAuth is a middleware accepting the requests only if the user
+// has authenticated within maxAge (step-up authentication).
+// RequireRecentAuth must be placed after the Set, Chk or Vet middleware.
+// The stale sessions are redirected to the optional redirect URL (e.g. "/login"),
+// else to the login page (see WithLoginRedirect), else they are rejected with "401 Unauthorized" (ReasonStaleAuth)
+// and a "WWW-Authenticate" challenge including the "max_age" parameter (RFC 9470).
+func (incorr *Incorruptible) RequireRecentAuth(maxAge time.Duration, redirect ...string) func(http.Handler) http.Handler {
+	if incorr.authTimeKey < 0 {
+		log.Panic("RequireRecentAuth", errNoAuthTimeKey)
+	}
+	if len(redirect) > 1 {
+		log.Panicf("RequireRecentAuth accepts only one redirect URL, got %v", redirect)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tv, ok := currentValues(r)
+			if !ok {
+				incorr.reject(w, r, fmt.Errorf("%w: RequireRecentAuth needs the Set, Chk or Vet middleware", ReasonMissing))
+				return
+			}
+
+			err := incorr.verifyRecentAuth(tv, maxAge)
+			if err == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			incorr.log.rejected("recent_auth", r, err)
+
+			if len(redirect) > 0 {
+				http.Redirect(w, r, redirect[0], http.StatusSeeOther)
+				return
+			}
+			if incorr.redirectLogin(w, r) {
+				return
+			}
+
+			w.Header().Set("WWW-Authenticate", incorr.challenge(ReasonStaleAuth)+
+				", max_age="+strconv.Itoa(int(maxAge.Seconds())))
+			incorr.writeErr(w, r, ReasonStaleAuth.StatusCode(), err)
+		})
+	}
+}
+
+func (incorr *Incorruptible) verifyRecentAuth(tv TValues, maxAge time.Duration) error {
+	t, err := incorr.AuthTime(tv)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ReasonStaleAuth, err)
+	}
+	if age := time.Since(t); age > maxAge {
+		return fmt.Errorf("%w: authenticated %v ago > max=%v", ReasonStaleAuth, age.Truncate(time.Second), maxAge)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func TestRequireRecentAuth(t *testing.T) {
+	t.Parallel()
+
+	const keyAuthTime, keyUser = 0, 1
+
+	incorr := newTestIncorr(t, "https://example.com/", incorruptible.WithAuthTimeKey(keyAuthTime))
+
+	// login one hour ago
+	tv, err := incorruptible.NewTValues(incorruptible.String(keyUser, "alice"))
+	if err != nil {
+		t.Fatal("NewTValues() error", err)
+	}
+	tv.SetExpiry(3 * 3600)
+	if err = incorr.SetAuthTime(&tv, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal("SetAuthTime() error", err)
+	}
+	stale, err := incorr.NewCookieFromValues(tv)
+	if err != nil {
+		t.Fatal("NewCookieFromValues() error", err)
+	}
+
+	serve := func(h http.Handler, c *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "https://example.com/withdraw", http.NoBody)
+		r.AddCookie(c)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// stale session => challenge
+	w := serve(incorr.Chk(incorr.RequireRecentAuth(5*time.Minute)(okHandler)), stale)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("stale session status = %d, want 401", w.Code)
+	}
+	challenge := w.Header().Get("WWW-Authenticate")
+	if !strings.Contains(challenge, `error="insufficient_user_authentication"`) || !strings.Contains(challenge, "max_age=300") {
+		t.Errorf("WWW-Authenticate = %q", challenge)
+	}
+
+	// stale session => redirect
+	w = serve(incorr.Chk(incorr.RequireRecentAuth(5*time.Minute, "/login")(okHandler)), stale)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login" {
+		t.Errorf("stale session status = %d Location=%q, want 303 /login", w.Code, w.Header().Get("Location"))
+	}
+
+	// re-authentication => cookie re-issued with the other values
+	reauth := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := incorr.RefreshAuthTime(r); err != nil {
+			t.Error("RefreshAuthTime() error", err)
+		}
+	})
+	w = serve(incorr.Chk(reauth), stale)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("RefreshAuthTime() want one cookie, got %d", len(cookies))
+	}
+
+	w = serve(incorr.Chk(incorr.RequireRecentAuth(5*time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tv, _ := incorruptible.FromCtx(r)
+		if user := tv.StringIfAny(keyUser); user != "alice" {
+			t.Errorf("user = %q, want alice", user)
+		}
+	}))), cookies[0])
+	if w.Code != http.StatusOK {
+		t.Errorf("fresh session status = %d, want 200", w.Code)
+	}
+}
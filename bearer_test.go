@@ -0,0 +1,92 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func TestBearerToken(t *testing.T) {
+	t.Parallel()
+
+	u, err := url.Parse("https://example.com/")
+	if err != nil {
+		t.Fatal("url.Parse() error", err)
+	}
+
+	secretKey := []byte("1234567890" + "123456")
+	incorr := incorruptible.New(nil, []*url.URL{u}, secretKey, "session", 3600, false)
+	raw := incorruptible.New(nil, []*url.URL{u}, secretKey, "session", 3600, false,
+		incorruptible.WithAuthScheme("Token"),
+		incorruptible.WithTokenScheme(""))
+
+	tv := incorruptible.EmptyTValues()
+	tv.SetExpiry(3600)
+
+	token, err := incorr.Encode(tv)
+	if err != nil {
+		t.Fatal("Encode() error", err)
+	}
+
+	rawToken, err := raw.Encode(tv)
+	if err != nil {
+		t.Fatal("Encode() error", err)
+	}
+
+	cases := []struct {
+		name    string
+		incorr  *incorruptible.Incorruptible
+		headers []string
+		wantErr bool
+	}{
+		{"canonical", incorr, []string{"Bearer i:" + token}, false},
+		{"lowercase", incorr, []string{"bearer i:" + token}, false},
+		{"uppercase", incorr, []string{"BEARER I:" + token}, false},
+		{"whitespace", incorr, []string{" \tBearer \t  i:" + token + "\t "}, false},
+		{"other scheme first", incorr, []string{"Basic dXNlcjpwYXNz", "Bearer i:" + token}, false},
+		{"custom scheme raw token", raw, []string{"token " + rawToken}, false},
+
+		{"missing", incorr, nil, true},
+		{"empty", incorr, []string{""}, true},
+		{"scheme only", incorr, []string{"Bearer"}, true},
+		{"scheme and URI only", incorr, []string{"Bearer i:"}, true},
+		{"no scheme", incorr, []string{"i:" + token}, true},
+		{"no URI scheme", incorr, []string{"Bearer " + token}, true},
+		{"glued scheme", incorr, []string{"Beareri:" + token}, true},
+		{"other scheme only", incorr, []string{"Basic i:" + token}, true},
+		{"trailing garbage", incorr, []string{"Bearer i:" + token + " extra"}, true},
+		{"ambiguous", incorr, []string{"Bearer i:" + token, "bearer i:" + token}, true},
+		{"homoglyph scheme", incorr, []string{"Bеarer i:" + token}, true},
+		{"NUL bytes", incorr, []string{"Bearer i:" + strings.Repeat("\x00", len(token))}, true},
+		{"truncated", incorr, []string{"Bearer i:" + token[:len(token)-1]}, true},
+		{"huge", incorr, []string{"Bearer i:" + strings.Repeat(token, 1000)}, true},
+		{"default scheme on custom", raw, []string{"Bearer " + rawToken}, true},
+	}
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+			for _, h := range c.headers {
+				r.Header.Add("Authorization", h)
+			}
+
+			_, err := c.incorr.DecodeBearerToken(r)
+			if (err != nil) != c.wantErr {
+				t.Errorf("DecodeBearerToken() error = %v, wantErr %v", err, c.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxCookieSize is the maximum size of the cookie name plus its value.
+	// Browsers silently drop the cookies exceeding 4096 bytes.
+	// Above this size, SetCookie splits the value across several cookies.
+	MaxCookieSize = 4000
+
+	// MaxChunks is the maximum number of cookies conveying a large token.
+	MaxChunks = 8
+
+	// chunkMarker starts the value of the main cookie when the token is chunked.
+	// The marker is followed by the number of chunks: "chunks:3".
+	chunkMarker = "chunks:"
+)
+
+// chunkName returns the name of the chunk #i: "name.0", "name.1"…
+func chunkName(name string, i int) string {
+	return name + "." + strconv.Itoa(i)
+}
+
+// SetCookie adds the "Set-Cookie" headers to the response.
+// When the cookie exceeds MaxCookieSize, its value is split across
+// the cookies "name.0", "name.1"… and the main cookie "name"
+// conveys the number of chunks.
+// SetCookie also deletes the stale chunks sent by the request
+// (when the token shrinks). The request may be nil.
+func (incorr *Incorruptible) SetCookie(w http.ResponseWriter, r *http.Request, cookie *http.Cookie) error {
+	cookies, err := splitCookie(r, cookie)
+	if err != nil {
+		return err
+	}
+	for _, c := range cookies {
+		http.SetCookie(w, c)
+	}
+	return nil
+}
+
+// NewCookies is like NewCookie but returns all the cookies to set:
+// the chunks and the main cookie when the token exceeds MaxCookieSize,
+// and the deletion of the stale chunks sent by the request.
+//
+// Example:
+//
+//	func login(w http.ResponseWriter, r *http.Request) {
+//	    cookies, _, err := Incorruptible.NewCookies(r, incorruptible.String(0, "alice"))
+//	    ...
+//	    for _, c := range cookies {
+//	        http.SetCookie(w, c)
+//	    }
+//	}
+func (incorr *Incorruptible) NewCookies(r *http.Request, keyValues ...KVal) ([]*http.Cookie, TValues, error) {
+	cookie, tv, err := incorr.NewCookie(r, keyValues...)
+	if err != nil {
+		return nil, tv, err
+	}
+	cookies, err := splitCookie(r, cookie)
+	return cookies, tv, err
+}
+
+// splitCookie returns the chunks followed by the main cookie
+// when the cookie exceeds MaxCookieSize, else the cookie only,
+// and finally a dead cookie for each stale chunk sent by the request.
+func splitCookie(r *http.Request, cookie *http.Cookie) ([]*http.Cookie, error) {
+	var cookies []*http.Cookie
+	n := 0
+
+	size := MaxCookieSize - len(cookie.Name) - len(".0")
+	if len(cookie.Name)+len(cookie.Value) <= MaxCookieSize {
+		cookies = append(cookies, cookie)
+	} else {
+		n = (len(cookie.Value) + size - 1) / size
+		if n > MaxChunks {
+			return nil, fmt.Errorf("cookie %s too large: %d bytes requires %d chunks > max=%d",
+				cookie.Name, len(cookie.Value), n, MaxChunks)
+		}
+
+		for i := 0; i < n; i++ {
+			chunk := *cookie // local copy
+			chunk.Name = chunkName(cookie.Name, i)
+			chunk.Value = cookie.Value[i*size : min((i+1)*size, len(cookie.Value))]
+			cookies = append(cookies, &chunk)
+		}
+
+		base := *cookie // local copy
+		base.Value = chunkMarker + strconv.Itoa(n)
+		cookies = append(cookies, &base)
+	}
+
+	// delete the stale chunks
+	if r != nil {
+		for i := n; i < MaxChunks; i++ {
+			name := chunkName(cookie.Name, i)
+			if _, err := r.Cookie(name); err == nil {
+				dead := *cookie // local copy
+				dead.Name = name
+				dead.Value = ""
+				dead.MaxAge = -1
+				cookies = append(cookies, &dead)
+			}
+		}
+	}
+
+	return cookies, nil
+}
+
+// joinChunks reassembles the value of a chunked cookie.
+func joinChunks(r *http.Request, name, marker string) (string, error) {
+	n, err := strconv.Atoi(marker[len(chunkMarker):])
+	if err != nil || n < 1 || n > MaxChunks {
+		return "", fmt.Errorf("%w: cookie %s has a bad chunk marker", ReasonInvalid, name)
+	}
+
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		chunk, err := r.Cookie(chunkName(name, i))
+		if err != nil {
+			return "", fmt.Errorf("%w: cookie %s: %w", ReasonInvalid, chunkName(name, i), err)
+		}
+		b.WriteString(chunk.Value)
+	}
+
+	return b.String(), nil
+}
+
+// DeadCookies returns the dead main cookie (see DeadCookieFor)
+// and a dead cookie for each chunk sent by the request.
+// When the request is nil, DeadCookies returns a dead cookie for all possible chunks.
+//
+// Example:
+//
+//	func logout(w http.ResponseWriter, r *http.Request) {
+//	    for _, c := range Incorruptible.DeadCookies(r) {
+//	        http.SetCookie(w, c)
+//	    }
+//	}
+func (incorr *Incorruptible) DeadCookies(r *http.Request) []*http.Cookie {
+	base := incorr.DeadCookieFor(r)
+	cookies := []*http.Cookie{base}
+
+	for i := 0; i < MaxChunks; i++ {
+		name := chunkName(base.Name, i)
+		if r != nil {
+			if _, err := r.Cookie(name); err != nil {
+				continue
+			}
+		}
+		dead := *base // local copy
+		dead.Name = name
+		cookies = append(cookies, &dead)
+	}
+
+	return cookies
+}
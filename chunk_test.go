@@ -0,0 +1,149 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func TestSetCookie_Chunks(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com/")
+
+	// random values are poorly compressible => large token
+	tv := incorruptible.EmptyTValues()
+	tv.SetExpiry(3600)
+	for i := 0; i < incorruptible.MaxValues; i++ {
+		v := make([]byte, 125)
+		rand.Read(v)
+		tv.Values = append(tv.Values, append(v, v...))
+	}
+
+	large, err := incorr.NewCookieFromValues(tv)
+	if err != nil {
+		t.Fatal("NewCookieFromValues() error", err)
+	}
+
+	w := httptest.NewRecorder()
+	if err = incorr.SetCookie(w, nil, large); err != nil {
+		t.Fatal("SetCookie() error", err)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) < 3 {
+		t.Fatalf("want the main cookie + several chunks, got %d cookies", len(cookies))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	for _, c := range cookies {
+		if len(c.Name)+len(c.Value) > incorruptible.MaxCookieSize {
+			t.Errorf("cookie %s too large: %d bytes", c.Name, len(c.Value))
+		}
+		r.AddCookie(c)
+	}
+
+	got, err := incorr.DecodeCookieToken(r)
+	if err != nil {
+		t.Fatal("DecodeCookieToken() error", err)
+	}
+	if len(got.Values) != len(tv.Values) {
+		t.Errorf("DecodeCookieToken() got %d values, want %d", len(got.Values), len(tv.Values))
+	}
+
+	// the token shrinks => the chunks are deleted
+	small, _, err := incorr.NewCookie(r)
+	if err != nil {
+		t.Fatal("NewCookie() error", err)
+	}
+
+	w = httptest.NewRecorder()
+	if err = incorr.SetCookie(w, r, small); err != nil {
+		t.Fatal("SetCookie() error", err)
+	}
+
+	cookies = w.Result().Cookies()
+	if len(cookies) != len(incorr.DeadCookies(r)) {
+		t.Errorf("want the main cookie and the deleted chunks, got %d cookies", len(cookies))
+	}
+	for _, c := range cookies[1:] {
+		if c.MaxAge >= 0 {
+			t.Errorf("stale chunk %s not deleted", c.Name)
+		}
+	}
+}
+
+func TestNewCookies_Chunks(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com/")
+
+	// random values are poorly compressible => large token
+	kv := make([]incorruptible.KVal, 0, incorruptible.MaxValues)
+	for i := 0; i < incorruptible.MaxValues; i++ {
+		v := make([]byte, 125)
+		rand.Read(v)
+		kv = append(kv, incorruptible.String(i, string(v)+string(v)))
+	}
+
+	// login: the handler sets all the cookies returned by NewCookies
+	login := incorr.Set(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookies, _, err := incorr.NewCookies(r, kv...)
+		if err != nil {
+			t.Error("NewCookies() error", err)
+		}
+		for _, c := range cookies {
+			http.SetCookie(w, c)
+		}
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/login", http.NoBody)
+	w := httptest.NewRecorder()
+	login.ServeHTTP(w, r)
+
+	var main *http.Cookie
+	r = httptest.NewRequest(http.MethodGet, "https://example.com/logout", http.NoBody)
+	for _, c := range w.Result().Cookies() {
+		if len(c.Name)+len(c.Value) > incorruptible.MaxCookieSize {
+			t.Errorf("cookie %s too large: %d bytes", c.Name, len(c.Value))
+		}
+		if c.Name == incorr.CookieName() {
+			main = c
+		}
+		r.AddCookie(c)
+	}
+	if main == nil || len(w.Result().Cookies()) < 3 {
+		t.Fatalf("want the main cookie + several chunks, got %v", w.Result().Cookies())
+	}
+
+	if _, err := incorr.DecodeCookieToken(r); err != nil {
+		t.Fatal("DecodeCookieToken() error", err)
+	}
+
+	// logout outside Set/Chk: DeadCookies also deletes the chunks
+	logout := incorr.Vet(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, c := range incorr.DeadCookies(r) {
+			http.SetCookie(w, c)
+		}
+	}))
+
+	w = httptest.NewRecorder()
+	logout.ServeHTTP(w, r)
+	cookies := w.Result().Cookies()
+	if len(cookies) != len(r.Cookies()) {
+		t.Errorf("want %d deleted cookies, got %v", len(r.Cookies()), cookies)
+	}
+	for _, c := range cookies {
+		if c.MaxAge >= 0 {
+			t.Errorf("cookie %s not deleted", c.Name)
+		}
+	}
+}
@@ -104,18 +104,32 @@ func NewChaCipher(secretKey []byte) cipher.AEAD {
 //
 //nolint:gosec // strong random generator not required for nonce
 func Encrypt(aead cipher.AEAD, plaintext []byte) []byte {
+	return EncryptAD(aead, plaintext, nil)
+}
+
+// EncryptAD is similar to Encrypt but also authenticates the additional data.
+// The additional data is not part of the output: Decrypt must be given the same additional data.
+//
+//nolint:gosec // strong random generator not required for nonce
+func EncryptAD(aead cipher.AEAD, plaintext, additionalData []byte) []byte {
 	// the variable "all" will contain the nonce + the ciphertext + the potential GCM tag
 	all := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+gcmTagSize)
 	rand.Read(all) // write the nonce part only
-	return aead.Seal(all, all, plaintext, nil)
+	return aead.Seal(all, all, plaintext, additionalData)
 }
 
 // Decrypt decrypts the ciphertext using any AEAD cipher.
 // The parameter "all" contains the nonce + the ciphertext + the potential GCM tag.
 // in the format "nonce|ciphertext|tag" where '|' indicates concatenation.
 func Decrypt(aead cipher.AEAD, all []byte) (plaintext []byte, err error) {
+	return DecryptAD(aead, all, nil)
+}
+
+// DecryptAD decrypts the ciphertext and verifies the additional data
+// was the same as the one provided to EncryptAD.
+func DecryptAD(aead cipher.AEAD, all, additionalData []byte) (plaintext []byte, err error) {
 	nSize := aead.NonceSize()
 	nonce, ciphertext := all[:nSize], all[nSize:]
 	dst := ciphertext[:0]
-	return aead.Open(dst, nonce, ciphertext, nil)
+	return aead.Open(dst, nonce, ciphertext, additionalData)
 }
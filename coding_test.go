@@ -6,6 +6,7 @@
 package incorruptible_test
 
 import (
+	"crypto/rand"
 	"net"
 	"reflect"
 	"testing"
@@ -187,3 +188,28 @@ var codingDataCases = []struct {
 		},
 	},
 }
+
+func TestMarshal_Incompressible(t *testing.T) {
+	t.Parallel()
+
+	// random data is not compressible: the compressed payload would be larger
+	buf := make([]byte, 200)
+	_, _ = rand.Read(buf)
+
+	tv, err := incorruptible.NewTValues(incorruptible.String(0, string(buf)))
+	if err != nil {
+		t.Fatal("NewTValues() error", err)
+	}
+
+	b, err := incorruptible.Marshal(tv, 0x51)
+	if err != nil {
+		t.Fatal("Marshal() error", err)
+	}
+	got, err := incorruptible.Unmarshal(b)
+	if err != nil {
+		t.Fatal("Unmarshal() error", err)
+	}
+	if !reflect.DeepEqual(got.Values, tv.Values) {
+		t.Errorf("Mismatch Values got %v, want %v", got.Values, tv.Values)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CookiePrefix selects the cookie name prefix.
+// See https://developer.mozilla.org/docs/Web/HTTP/Headers/Set-Cookie#cookie_prefixes
+type CookiePrefix int
+
+const (
+	// PrefixAuto (default) uses "__Host-" when the cookie is Secure with "Path=/",
+	// "__Secure-" when the cookie is Secure with another Path or with a Domain,
+	// and no prefix when the cookie is not Secure or when the cookie name starts with "_".
+	PrefixAuto CookiePrefix = iota
+	// PrefixHost requires the "__Host-" prefix: Secure, no Domain and "Path=/".
+	PrefixHost
+	// PrefixSecure requires the "__Secure-" prefix: Secure.
+	PrefixSecure
+	// PrefixNone disables the cookie prefix.
+	PrefixNone
+)
+
+const (
+	hostPrefix   = "__Host-"
+	securePrefix = "__Secure-"
+)
+
+// cookieAttrs gathers the cookie attributes customized by the options.
+type cookieAttrs struct {
+	sameSite    http.SameSite
+	partitioned bool
+	domain      string
+	prefix      CookiePrefix
+	expires     bool
+}
+
+// defaultCookieAttrs uses SameSite=Strict, this works when using two backends like:
+// localhost:3000 (node) and localhost:8080 (API)
+// https://developer.mozilla.org/docs/Web/HTTP/Headers/Set-Cookie/SameSite
+func defaultCookieAttrs() cookieAttrs {
+	return cookieAttrs{
+		sameSite:    http.SameSiteStrictMode,
+		partitioned: false,
+		domain:      "",
+		prefix:      PrefixAuto,
+		expires:     false,
+	}
+}
+
+// WithSameSite sets the SameSite attribute of the cookie (default is Strict).
+// Use http.SameSiteLaxMode when the cookie must be sent on top-level navigations
+// from another site, as the OAuth redirects.
+// http.SameSiteNoneMode requires a secure URL (HTTPS).
+func WithSameSite(sameSite http.SameSite) Option {
+	return func(incorr *Incorruptible) {
+		incorr.attrs.sameSite = sameSite
+	}
+}
+
+// WithPartitioned sets the Partitioned attribute (CHIPS) of the cookie.
+// This allows the cookie of an embedded widget (iframe) within third-party sites,
+// usually in conjunction with WithSameSite(http.SameSiteNoneMode).
+// Partitioned requires a secure URL (HTTPS).
+func WithPartitioned() Option {
+	return func(incorr *Incorruptible) {
+		incorr.attrs.partitioned = true
+	}
+}
+
+// WithDomain sets the Domain attribute of the cookie in order to share the session
+// between sub-domains: WithDomain("example.com") shares the cookie between
+// "app.example.com" and "api.example.com". The domain must be the host of
+// the first URL or one of its parent domains.
+// The cookie cannot use the "__Host-" prefix, PrefixAuto selects "__Secure-".
+func WithDomain(domain string) Option {
+	return func(incorr *Incorruptible) {
+		incorr.attrs.domain = strings.TrimPrefix(domain, ".")
+	}
+}
+
+// WithCookiePrefix sets the policy of the cookie name prefix (default is PrefixAuto).
+func WithCookiePrefix(prefix CookiePrefix) Option {
+	return func(incorr *Incorruptible) {
+		if prefix < PrefixAuto || prefix > PrefixNone {
+			log.Panicf("WithCookiePrefix(%d) unexpected value", prefix)
+		}
+		incorr.attrs.prefix = prefix
+	}
+}
+
+// WithExpiresFallback also sets the Expires attribute of the cookie
+// for old clients not supporting Max-Age.
+func WithExpiresFallback() Option {
+	return func(incorr *Incorruptible) {
+		incorr.attrs.expires = true
+	}
+}
+
+// resolve returns the prefix to prepend to the cookie name
+// or an error when the cookie does not satisfy the prefix requirements.
+func (p CookiePrefix) resolve(name string, secure bool, dir, domain string) (string, error) {
+	switch p {
+	case PrefixNone:
+		return "", nil
+
+	case PrefixHost:
+		if !secure {
+			return "", errors.New("prefix __Host- requires a secure URL (HTTPS)")
+		}
+		if domain != "" {
+			return "", fmt.Errorf("prefix __Host- forbids Domain=%s", domain)
+		}
+		if dir != "/" {
+			return "", fmt.Errorf("prefix __Host- requires Path=/ but got Path=%s", dir)
+		}
+		return hostPrefix, nil
+
+	case PrefixSecure:
+		if !secure {
+			return "", errors.New("prefix __Secure- requires a secure URL (HTTPS)")
+		}
+		return securePrefix, nil
+
+	default: // PrefixAuto
+		if !secure || name[0] == '_' {
+			return "", nil
+		}
+		if dir == "/" && domain == "" {
+			return hostPrefix, nil
+		}
+		return securePrefix, nil
+	}
+}
+
+// check rejects the attribute combinations refused by the browsers.
+func (a cookieAttrs) check(secure bool) error {
+	if a.sameSite == http.SameSiteNoneMode && !secure {
+		return errors.New("SameSite=None requires a secure URL (HTTPS)")
+	}
+	if a.partitioned && !secure {
+		return errors.New("Partitioned requires a secure URL (HTTPS)")
+	}
+	return nil
+}
+
+// domainMatch returns true when domain is the host or one of its parent domains.
+func domainMatch(host, domain string) bool {
+	host = strings.ToLower(host)
+	domain = strings.ToLower(domain)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// setExpires sets the Expires attribute from Max-Age when WithExpiresFallback is enabled.
+func (incorr *Incorruptible) setExpires(cookie *http.Cookie) {
+	if !incorr.attrs.expires {
+		return
+	}
+	switch {
+	case cookie.MaxAge < 0:
+		cookie.Expires = time.Unix(1, 0) // "delete cookie now"
+	case cookie.MaxAge > 0:
+		cookie.Expires = time.Now().Add(time.Duration(cookie.MaxAge) * time.Second)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func TestCookieAttributes(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name        string
+		url         string
+		opts        []incorruptible.Option
+		wantName    string
+		wantDomain  string
+		wantSite    http.SameSite
+		partitioned bool
+	}{
+		{"default https", "https://example.com/", nil, "__Host-session", "", http.SameSiteStrictMode, false},
+		{"default http", "http://localhost/", nil, "session", "localhost", http.SameSiteStrictMode, false},
+		{"sub-path", "https://example.com/app/", nil, "__Secure-session", "example.com", http.SameSiteStrictMode, false},
+		{
+			"lax", "https://example.com/",
+			[]incorruptible.Option{incorruptible.WithSameSite(http.SameSiteLaxMode)},
+			"__Host-session", "", http.SameSiteLaxMode, false,
+		},
+		{
+			"widget", "https://widget.example.com/",
+			[]incorruptible.Option{incorruptible.WithSameSite(http.SameSiteNoneMode), incorruptible.WithPartitioned()},
+			"__Host-session", "", http.SameSiteNoneMode, true,
+		},
+		{
+			"shared domain", "https://app.example.com/",
+			[]incorruptible.Option{incorruptible.WithDomain(".example.com")},
+			"__Secure-session", "example.com", http.SameSiteStrictMode, false,
+		},
+		{
+			"no prefix", "https://example.com/",
+			[]incorruptible.Option{incorruptible.WithCookiePrefix(incorruptible.PrefixNone)},
+			"session", "example.com", http.SameSiteStrictMode, false,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			cookie := newTestIncorr(t, c.url, c.opts...).Cookie(0)
+			if cookie.Name != c.wantName {
+				t.Errorf("Name got %q want %q", cookie.Name, c.wantName)
+			}
+			if cookie.Domain != c.wantDomain {
+				t.Errorf("Domain got %q want %q", cookie.Domain, c.wantDomain)
+			}
+			if cookie.SameSite != c.wantSite {
+				t.Errorf("SameSite got %v want %v", cookie.SameSite, c.wantSite)
+			}
+			if cookie.Partitioned != c.partitioned {
+				t.Errorf("Partitioned got %v want %v", cookie.Partitioned, c.partitioned)
+			}
+		})
+	}
+}
+
+func TestCookieAttributes_Rejected(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		url  string
+		opts []incorruptible.Option
+	}{
+		{"__Host- with Domain", "https://app.example.com/", []incorruptible.Option{
+			incorruptible.WithDomain("example.com"), incorruptible.WithCookiePrefix(incorruptible.PrefixHost),
+		}},
+		{"__Host- with sub-path", "https://example.com/app/", []incorruptible.Option{
+			incorruptible.WithCookiePrefix(incorruptible.PrefixHost),
+		}},
+		{"__Secure- without HTTPS", "http://localhost/", []incorruptible.Option{
+			incorruptible.WithCookiePrefix(incorruptible.PrefixSecure),
+		}},
+		{"SameSite=None without HTTPS", "http://localhost/", []incorruptible.Option{
+			incorruptible.WithSameSite(http.SameSiteNoneMode),
+		}},
+		{"Partitioned without HTTPS", "http://localhost/", []incorruptible.Option{
+			incorruptible.WithPartitioned(),
+		}},
+		{"foreign Domain", "https://app.example.com/", []incorruptible.Option{
+			incorruptible.WithDomain("example.org"),
+		}},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			defer func() {
+				if recover() == nil {
+					t.Error("New() must panic")
+				}
+			}()
+
+			newTestIncorr(t, c.url, c.opts...)
+		})
+	}
+}
+
+func TestExpiresFallback(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com/", incorruptible.WithExpiresFallback())
+
+	cookie, _, err := incorr.NewCookie(nil)
+	if err != nil {
+		t.Fatal("NewCookie() error", err)
+	}
+	if cookie.Expires.IsZero() {
+		t.Error("NewCookie() must set Expires")
+	}
+
+	if dead := incorr.DeadCookie(); dead.Expires.IsZero() {
+		t.Error("DeadCookie() must set Expires")
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	crand "crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// CSRFHeader is the request header conveying the CSRF token (SPA, fetch, XHR).
+	CSRFHeader = "X-CSRF-Token"
+	// CSRFField is the form field conveying the CSRF token (HTML forms).
+	CSRFField = "csrf_token"
+
+	csrfSecretSize = 16
+)
+
+var (
+	// ErrCSRFNoSession means the CSRF secret cannot be stored because
+	// the request has not been processed by the Set or Chk middlewares.
+	ErrCSRFNoSession = errors.New("CSRF requires the Set or Chk middleware")
+	// ErrCSRFOrigin means the request comes from an untrusted origin.
+	ErrCSRFOrigin = errors.New("CSRF untrusted origin")
+	// ErrCSRFMissing means the request conveys no CSRF token.
+	ErrCSRFMissing = errors.New("CSRF missing token")
+	// ErrCSRFInvalid means the CSRF token does not match the session secret.
+	ErrCSRFInvalid = errors.New("CSRF invalid token")
+)
+
+// WithCSRF enables the CSRF protection (see CSRF and CSRFToken).
+// The per-session CSRF secret is stored in the token values at the given key.
+func WithCSRF(key int) Option {
+	return func(incorr *Incorruptible) {
+		if err := checkWrite(key); err != nil {
+			log.Panic("WithCSRF", err)
+		}
+		incorr.csrfKey = key
+	}
+}
+
+// WithCSRFExempt disables the CSRF verification for some paths,
+// as the webhooks authenticated by other means.
+// A path ending with a slash "/" exempts all its sub-paths.
+func WithCSRFExempt(paths ...string) Option {
+	return func(incorr *Incorruptible) {
+		incorr.csrfExempt = append(incorr.csrfExempt, paths...)
+	}
+}
+
+// WithCSRFCookie also sets a cookie readable by JavaScript (no HttpOnly)
+// conveying a CSRF token: the SPA copies the cookie value in the CSRFHeader
+// (double-submit pattern). The token is verified against the session secret,
+// so a cookie injected by a sibling sub-domain is rejected.
+func WithCSRFCookie(name string) Option {
+	return func(incorr *Incorruptible) {
+		incorr.csrfCookie = name
+	}
+}
+
+// WithTrustedOrigins adds origins (e.g. "https://login.example.com")
+// allowed to send cross-origin requests, in addition to the URLs passed to New.
+func WithTrustedOrigins(origins ...string) Option {
+	return func(incorr *Incorruptible) {
+		for _, o := range origins {
+			incorr.origins = append(incorr.origins, strings.ToLower(strings.TrimSuffix(o, "/")))
+		}
+	}
+}
+
+// originsOf returns the origins "scheme://host[:port]" of the URLs.
+func originsOf(urls []*url.URL) []string {
+	origins := make([]string, 0, len(urls))
+	for _, u := range urls {
+		origins = append(origins, strings.ToLower(u.Scheme+"://"+u.Host))
+	}
+	return origins
+}
+
+// allowedOrigin returns true when the origin is one of the URLs passed to New
+// or one of the trusted origins (see WithTrustedOrigins).
+func (incorr *Incorruptible) allowedOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, o := range incorr.origins {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// CSRFToken returns a CSRF token to insert in the HTML forms (field CSRFField)
+// or to send within the header CSRFHeader. The per-session secret is created
+// on the first call, and the cookie is re-issued (see Session).
+// The token is masked with a random value: a new token is returned
+// at each call, but all tokens remain valid during the session.
+// CSRFToken requires WithCSRF and the Set or Chk middleware.
+func (incorr *Incorruptible) CSRFToken(r *http.Request) (string, error) {
+	if incorr.csrfKey < 0 {
+		return "", errors.New("CSRFToken requires the option WithCSRF")
+	}
+
+	s, ok := SessionFromCtx(r)
+	if !ok {
+		return "", ErrCSRFNoSession
+	}
+
+	var secret []byte
+	err := s.Update(func(tv *TValues) error {
+		str, err := tv.String(incorr.csrfKey)
+		if err == nil && len(str) == csrfSecretSize {
+			secret = []byte(str)
+			return errUnchanged
+		}
+		secret = make([]byte, csrfSecretSize)
+		if _, err := crand.Read(secret); err != nil {
+			return err
+		}
+		return tv.SetString(incorr.csrfKey, string(secret))
+	})
+	if err != nil && !errors.Is(err, errUnchanged) {
+		return "", err
+	}
+
+	return maskCSRF(secret)
+}
+
+// errUnchanged aborts a Session.Update without error.
+var errUnchanged = errors.New("unchanged")
+
+// maskCSRF returns base64url( mask | mask XOR secret )
+// to mitigate BREACH-like attacks on compressed responses.
+func maskCSRF(secret []byte) (string, error) {
+	buf := make([]byte, 2*len(secret))
+	mask := buf[:len(secret)]
+	if _, err := crand.Read(mask); err != nil {
+		return "", err
+	}
+	for i := range secret {
+		buf[len(secret)+i] = mask[i] ^ secret[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func unmaskCSRF(token string) ([]byte, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(buf) != 2*csrfSecretSize {
+		return nil, fmt.Errorf("%w: malformed", ErrCSRFInvalid)
+	}
+	secret := buf[csrfSecretSize:]
+	for i := range secret {
+		secret[i] ^= buf[i]
+	}
+	return secret, nil
+}
+
+// CSRF is a middleware verifying the unsafe requests (POST, PUT, PATCH, DELETE…)
+// against Cross-Site Request Forgery. CSRF must be placed after the Set or Chk middleware.
+// The request must come from a trusted origin (headers "Sec-Fetch-Site" and "Origin")
+// and must convey a CSRF token (header CSRFHeader or form field CSRFField)
+// matching the session secret (synchronizer pattern).
+// The rejected requests receive "403 Forbidden".
+// CSRF requires the option WithCSRF.
+func (incorr *Incorruptible) CSRF(next http.Handler) http.Handler {
+	if incorr.csrfKey < 0 {
+		log.Panic("Middleware Incorruptible.CSRF requires the option WithCSRF")
+	}
+
+	incorr.log.Security("Middleware", AttrEvent, "middleware", "name", "CSRF",
+		"exempt", incorr.csrfExempt, "origins", incorr.origins)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isSafeMethod(r.Method) {
+			incorr.setCSRFCookie(w, r)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := incorr.VerifyCSRF(r); err != nil {
+			incorr.log.rejected("csrf", r, err)
+			incorr.writeErr(w, r, http.StatusForbidden, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// VerifyCSRF returns nil when the request is exempted or passes the CSRF verifications,
+// else the error wraps ErrCSRFOrigin, ErrCSRFMissing, ErrCSRFInvalid or ErrCSRFNoSession.
+func (incorr *Incorruptible) VerifyCSRF(r *http.Request) error {
+	if matchPath(incorr.csrfExempt, r.URL.Path) {
+		return nil
+	}
+
+	if err := incorr.verifyOrigin(r); err != nil {
+		return err
+	}
+
+	token := r.Header.Get(CSRFHeader)
+	if token == "" {
+		token = r.PostFormValue(CSRFField)
+	}
+	if token == "" {
+		return ErrCSRFMissing
+	}
+
+	got, err := unmaskCSRF(token)
+	if err != nil {
+		return err
+	}
+
+	s, ok := SessionFromCtx(r)
+	if !ok {
+		return ErrCSRFNoSession
+	}
+	want, err := s.Values().String(incorr.csrfKey)
+	if err != nil {
+		return fmt.Errorf("%w: no secret in session", ErrCSRFInvalid)
+	}
+
+	if subtle.ConstantTimeCompare(got, []byte(want)) != 1 {
+		return ErrCSRFInvalid
+	}
+	return nil
+}
+
+// verifyOrigin relies on the Fetch Metadata sent by the modern browsers,
+// and on the "Origin" header sent by most browsers on unsafe requests.
+// Without both headers (old browsers, non-browser clients), only the token is verified.
+func (incorr *Incorruptible) verifyOrigin(r *http.Request) error {
+	site := r.Header.Get("Sec-Fetch-Site")
+	if site == "same-origin" || site == "none" {
+		return nil
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin != "" {
+		if origin == "null" || !incorr.allowedOrigin(origin) {
+			return fmt.Errorf("%w: Origin=%q", ErrCSRFOrigin, origin)
+		}
+		return nil
+	}
+
+	if site != "" { // "same-site" or "cross-site" without Origin
+		return fmt.Errorf("%w: Sec-Fetch-Site=%q", ErrCSRFOrigin, site)
+	}
+	return nil
+}
+
+// setCSRFCookie sets the CSRF cookie readable by JavaScript (see WithCSRFCookie).
+func (incorr *Incorruptible) setCSRFCookie(w http.ResponseWriter, r *http.Request) {
+	if incorr.csrfCookie == "" {
+		return
+	}
+
+	token, err := incorr.CSRFToken(r)
+	if err != nil {
+		incorr.log.Warn("CSRF cookie", AttrError, err)
+		return
+	}
+
+	base, _ := incorr.profile(r)
+	cookie := *base // local copy of the default cookie
+	cookie.Name = incorr.csrfCookie
+	cookie.Value = token
+	cookie.HttpOnly = false
+	incorr.setExpires(&cookie)
+	http.SetCookie(w, &cookie)
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func TestCSRF(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com/",
+		incorruptible.WithCSRF(0),
+		incorruptible.WithCSRFExempt("/hooks/"),
+		incorruptible.WithTrustedOrigins("https://login.example.com"))
+
+	handler := incorr.Set(incorr.CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			token, err := incorr.CSRFToken(r)
+			if err != nil {
+				t.Error("CSRFToken() error", err)
+			}
+			_, _ = w.Write([]byte(token))
+		}
+	})))
+
+	// GET the form => CSRF token + session cookie
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/form", http.NoBody))
+	token := w.Body.String()
+	cookies := w.Result().Cookies()
+	if token == "" || len(cookies) == 0 {
+		t.Fatalf("GET want a CSRF token and a cookie, got token=%q cookies=%d", token, len(cookies))
+	}
+
+	// alter the first character (never the same one)
+	badToken := "x" + token[1:]
+	if token[0] == 'x' {
+		badToken = "y" + token[1:]
+	}
+
+	cases := []struct {
+		name       string
+		path       string
+		header     http.Header
+		form       string
+		wantStatus int
+	}{
+		{"header", "/form", http.Header{incorruptible.CSRFHeader: {token}}, "", http.StatusOK},
+		{"form field", "/form", nil, incorruptible.CSRFField + "=" + url.QueryEscape(token), http.StatusOK},
+		{"missing token", "/form", nil, "", http.StatusForbidden},
+		{"bad token", "/form", http.Header{incorruptible.CSRFHeader: {badToken}}, "", http.StatusForbidden},
+		{"same origin", "/form", http.Header{
+			incorruptible.CSRFHeader: {token}, "Sec-Fetch-Site": {"same-origin"},
+		}, "", http.StatusOK},
+		{"trusted origin", "/form", http.Header{
+			incorruptible.CSRFHeader: {token}, "Sec-Fetch-Site": {"same-site"}, "Origin": {"https://login.example.com"},
+		}, "", http.StatusOK},
+		{"cross-site origin", "/form", http.Header{
+			incorruptible.CSRFHeader: {token}, "Origin": {"https://evil.org"},
+		}, "", http.StatusForbidden},
+		{"cross-site without origin", "/form", http.Header{
+			incorruptible.CSRFHeader: {token}, "Sec-Fetch-Site": {"cross-site"},
+		}, "", http.StatusForbidden},
+		{"exempt", "/hooks/github", http.Header{"Origin": {"https://github.com"}}, "", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodPost, "https://example.com"+c.path, strings.NewReader(c.form))
+			if c.form != "" {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			for k, v := range c.header {
+				r.Header.Add(k, v[0])
+			}
+			for _, cookie := range cookies {
+				r.AddCookie(cookie)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != c.wantStatus {
+				t.Errorf("POST status = %d, want %d body=%s", w.Code, c.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
@@ -10,7 +10,6 @@ package incorruptible
 import (
 	"errors"
 	"fmt"
-	"time"
 )
 
 const (
@@ -28,87 +27,106 @@ const (
 		"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
 		"abcdefghijklmnopqrstuvwxyz" +
 		"0123456789!#$%&()*+,-./:<=>?@[]^_`{|}~'"
-
-	doPrint = false
 )
 
 func (incorr *Incorruptible) Encode(tv TValues) (string, error) {
-	printV("Encode Marshal", tv, nil)
+	return incorr.encodeAD(tv, incorr.ad)
+}
+
+// Decode decodes the token of this Incorruptible (see Profile).
+// The valid token of another profile is rejected with ReasonWrongProfile
+// (not ReasonTampered): this is a client mistake, not an attack.
+func (incorr *Incorruptible) Decode(base91 string) (TValues, error) {
+	tv, err := incorr.decodeAD(base91, incorr.ad)
+	if errors.Is(err, ReasonTampered) {
+		if name, ok := incorr.otherProfile(base91); ok {
+			return tv, fmt.Errorf("%w: token of profile %q", ReasonWrongProfile, name)
+		}
+	}
+	return tv, err
+}
+
+// encodeAD serializes, encrypts and encodes the TValues.
+// The additional data binds the token to a context (e.g. a signed URL)
+// and must be provided again to decodeAD.
+// When a SessionStore is configured, the values exceeding the token budget
+// are spilled to the SessionStore (see WithSessionStore).
+func (incorr *Incorruptible) encodeAD(tv TValues, additionalData []byte) (string, error) {
+	str, err := incorr.encodeMagic(tv, incorr.magic, additionalData)
+	if incorr.store == nil || (err == nil && len(str) <= incorr.budget) {
+		return str, err
+	}
+	return incorr.spill(tv, additionalData)
+}
+
+func (incorr *Incorruptible) encodeMagic(tv TValues, magic uint8, additionalData []byte) (string, error) {
+	incorr.log.traceV("Encode Marshal", tv, nil)
 
-	plaintext, err := Marshal(tv, incorr.magic)
+	start := incorr.startStage()
+	plaintext, err := Marshal(tv, magic)
 	if err != nil {
 		return "", err
 	}
-	printB("Encode Encrypt plaintext", plaintext)
+	incorr.stageDone(StageCompress, start)
+	incorr.log.traceB("Encode Encrypt plaintext", plaintext)
 
-	nonceCiphertextAndTag := Encrypt(incorr.cipher, plaintext)
-	printB("Encode EncodeToString ciphertext", nonceCiphertextAndTag)
+	start = incorr.startStage()
+	nonceCiphertextAndTag := EncryptAD(incorr.cipher, plaintext, additionalData)
+	incorr.stageDone(StageEncrypt, start)
+	incorr.log.traceB("Encode EncodeToString ciphertext", nonceCiphertextAndTag)
 
+	start = incorr.startStage()
 	str := incorr.baseN.EncodeToString(nonceCiphertextAndTag)
-	printS("Encode result = BasE91", str)
+	incorr.stageDone(StageBase91Encode, start)
+	incorr.log.traceS("Encode result = BasE91", str)
 	return str, nil
 }
 
-func (incorr *Incorruptible) Decode(base91 string) (TValues, error) {
+func (incorr *Incorruptible) decodeAD(base91 string, additionalData []byte) (TValues, error) {
 	var tv TValues
 
-	printS("Decode DecodeString BasE91", base91)
+	incorr.log.traceS("Decode DecodeString BasE91", base91)
 
 	if len(base91) < Base91MinSize {
-		return tv, fmt.Errorf("BasE91 text too short: %d < min=%d", len(base91), Base91MinSize)
+		return tv, fmt.Errorf("%w: BasE91 text too short: %d < min=%d", ReasonInvalid, len(base91), Base91MinSize)
 	}
 
+	start := incorr.startStage()
 	encrypted, err := incorr.baseN.DecodeString(base91)
 	if err != nil {
-		return tv, err
+		return tv, fmt.Errorf("%w: %w", ReasonInvalid, err)
 	}
-	printB("Decode Decrypt", encrypted)
+	incorr.stageDone(StageBase91Decode, start)
+	incorr.log.traceB("Decode Decrypt", encrypted)
 
 	if len(encrypted) < encryptedMinSize {
-		return tv, fmt.Errorf("encrypted data too short: %d < min=%d", len(encrypted), encryptedMinSize)
+		return tv, fmt.Errorf("%w: encrypted data too short: %d < min=%d", ReasonInvalid, len(encrypted), encryptedMinSize)
 	}
 
-	plaintext, err := Decrypt(incorr.cipher, encrypted)
+	start = incorr.startStage()
+	plaintext, err := DecryptAD(incorr.cipher, encrypted, additionalData)
 	if err != nil {
-		return tv, err
+		return tv, fmt.Errorf("%w: %w", ReasonTampered, err)
 	}
-	printB("Decode Unmarshal plaintext", plaintext)
+	incorr.stageDone(StageDecrypt, start)
+	incorr.log.traceB("Decode Unmarshal plaintext", plaintext)
 
-	if MagicCode(plaintext) != incorr.magic {
-		return tv, errors.New("bad magic code")
+	magic := MagicCode(plaintext)
+	spilled := (incorr.store != nil) && (magic == incorr.spillMagic())
+	if magic != incorr.magic && !spilled {
+		return tv, fmt.Errorf("%w: bad magic code", ReasonTampered)
 	}
 
-	tv, err = Unmarshal(plaintext)
-	printV("Decode result", tv, err)
-	return tv, err
-}
-
-// printS prints a string in debug mode (when doPrint is true).
-func printS(name, s string) {
-	if doPrint {
-		n := len(s)
-		if n > 30 {
-			n = 30
-		}
-		log.Debugf("Incorr.%s len=%d %q", name, len(s), s[:n])
-	}
-}
-
-// printB prints a byte-buffer in debug mode (when doPrint is true).
-func printB(name string, buf []byte) {
-	if doPrint {
-		n := len(buf)
-		if n > 30 {
-			n = 30
-		}
-		log.Debugf("Incorr.%s len=%d cap=%d %x", name, len(buf), cap(buf), buf[:n])
+	start = incorr.startStage()
+	tv, err = unmarshal(plaintext, incorr.log)
+	incorr.log.traceV("Decode result", tv, err)
+	if err != nil {
+		return tv, fmt.Errorf("%w: %w", ReasonInvalid, err)
 	}
-}
+	incorr.stageDone(StageDecompress, start)
 
-// printV prints TValues in debug mode (when doPrint is true).
-func printV(name string, tv TValues, err error) {
-	if doPrint {
-		log.Debugf("Incorr.%s tv %v %v n=%d err=%s", name,
-			time.Unix(tv.Expires, 0), tv.IP, len(tv.Values), err)
+	if spilled {
+		return incorr.loadSpilled(tv)
 	}
+	return tv, nil
 }
@@ -0,0 +1,126 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TokenExtractor retrieves the token from a request.
+// The middlewares Set, Chk and Vet iterate over the configured extractors
+// (see WithExtractors) until a valid token is found.
+type TokenExtractor interface {
+	// Source identifies where the token is searched, e.g. "cookie" or "bearer".
+	Source() string
+	// Extract returns the token in BasE91 format (without the URI scheme).
+	Extract(incorr *Incorruptible, r *http.Request) (string, error)
+}
+
+// WithExtractors replaces the ordered list of token extractors.
+// The default list is CookieExtractor then BearerExtractor.
+func WithExtractors(extractors ...TokenExtractor) Option {
+	return func(incorr *Incorruptible) {
+		if len(extractors) == 0 {
+			log.Panic("WithExtractors requires at least one TokenExtractor")
+		}
+		incorr.extractors = extractors
+	}
+}
+
+func defaultExtractors() []TokenExtractor {
+	return []TokenExtractor{CookieExtractor{}, BearerExtractor{}}
+}
+
+type (
+	// CookieExtractor searches the token in the Incorruptible cookie.
+	CookieExtractor struct{}
+
+	// BearerExtractor searches the token in the "Authorization" header.
+	BearerExtractor struct{}
+
+	// HeaderExtractor searches the token in a custom header, e.g. "X-Api-Token".
+	// The header value is in the token URI format "i:xxxxxxxx" (see WithTokenScheme).
+	HeaderExtractor struct{ Name string }
+
+	// QueryExtractor searches the token in a query parameter, e.g. "access_token".
+	// The parameter value is in the token URI format "i:xxxxxxxx" (see WithTokenScheme).
+	QueryExtractor struct{ Param string }
+
+	// WebSocketExtractor searches the token in the "Sec-WebSocket-Protocol" header
+	// because browser WebSocket clients cannot set other headers.
+	// The client provides the subprotocol generated by WebSocketProtocol
+	// plus another subprotocol that the handler must select in its handshake
+	// response (browsers abort the handshake when no subprotocol is selected).
+	// The handler must never echo the subprotocol conveying the token.
+	WebSocketExtractor struct{}
+)
+
+func (CookieExtractor) Source() string    { return "cookie" }
+func (BearerExtractor) Source() string    { return "bearer" }
+func (ex HeaderExtractor) Source() string { return "header" }
+func (ex QueryExtractor) Source() string  { return "query" }
+func (WebSocketExtractor) Source() string { return "websocket" }
+
+func (CookieExtractor) Extract(incorr *Incorruptible, r *http.Request) (string, error) {
+	return incorr.CookieToken(r)
+}
+
+func (BearerExtractor) Extract(incorr *Incorruptible, r *http.Request) (string, error) {
+	return incorr.BearerToken(r)
+}
+
+func (ex HeaderExtractor) Extract(incorr *Incorruptible, r *http.Request) (string, error) {
+	uri := r.Header.Get(ex.Name)
+	if uri == "" {
+		return "", fmt.Errorf("%w: no header %s", ReasonMissing, ex.Name)
+	}
+	return incorr.trimTokenScheme(uri)
+}
+
+func (ex QueryExtractor) Extract(incorr *Incorruptible, r *http.Request) (string, error) {
+	values := r.URL.Query()[ex.Param]
+	switch len(values) {
+	case 0:
+		return "", fmt.Errorf("%w: no query parameter %s", ReasonMissing, ex.Param)
+	case 1:
+		return incorr.trimTokenScheme(values[0])
+	default:
+		return "", fmt.Errorf("%w: want one query parameter %s but got %d", ReasonMalformed, ex.Param, len(values))
+	}
+}
+
+// webSocketPrefix starts the subprotocol conveying the token.
+// The BasE91 alphabet contains characters forbidden in a subprotocol,
+// so the token is converted to unpadded Base64URL.
+const webSocketPrefix = "incorruptible."
+
+// WebSocketProtocol converts a token (as returned by Encode)
+// into a subprotocol name accepted by browser WebSocket clients:
+//
+//	new WebSocket("wss://example.com/ws", ["json", protocol])
+func WebSocketProtocol(token string) string {
+	return webSocketPrefix + base64.RawURLEncoding.EncodeToString([]byte(token))
+}
+
+func (WebSocketExtractor) Extract(_ *Incorruptible, r *http.Request) (string, error) {
+	for _, line := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(line, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if !strings.HasPrefix(protocol, webSocketPrefix) {
+				continue
+			}
+			token, err := base64.RawURLEncoding.DecodeString(protocol[len(webSocketPrefix):])
+			if err != nil {
+				return "", fmt.Errorf("%w: WebSocket subprotocol: %w", ReasonInvalid, err)
+			}
+			return string(token), nil
+		}
+	}
+	return "", fmt.Errorf("%w: no WebSocket subprotocol %sxxxxxxxx", ReasonMissing, webSocketPrefix)
+}
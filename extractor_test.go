@@ -0,0 +1,77 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func TestExtractors(t *testing.T) {
+	t.Parallel()
+
+	u, err := url.Parse("https://example.com/")
+	if err != nil {
+		t.Fatal("url.Parse() error", err)
+	}
+
+	secretKey := []byte("1234567890" + "123456")
+	incorr := incorruptible.New(nil, []*url.URL{u}, secretKey, "session", 3600, false,
+		incorruptible.WithExtractors(
+			incorruptible.HeaderExtractor{Name: "X-Api-Token"},
+			incorruptible.QueryExtractor{Param: "access_token"},
+			incorruptible.WebSocketExtractor{},
+		))
+
+	tv, err := incorruptible.NewTValues(incorruptible.String(0, "alice"))
+	if err != nil {
+		t.Fatal("NewTValues() error", err)
+	}
+	tv.SetExpiry(3600)
+
+	token, err := incorr.Encode(tv)
+	if err != nil {
+		t.Fatal("Encode() error", err)
+	}
+
+	cases := []struct {
+		name    string
+		set     func(r *http.Request)
+		wantErr bool
+	}{
+		{"header", func(r *http.Request) { r.Header.Set("X-Api-Token", "i:"+token) }, false},
+		{"query", func(r *http.Request) { r.URL.RawQuery = url.Values{"access_token": {"i:" + token}}.Encode() }, false},
+		{"websocket", func(r *http.Request) {
+			r.Header.Set("Sec-WebSocket-Protocol", "json, "+incorruptible.WebSocketProtocol(token))
+		}, false},
+		{"cookie not configured", func(r *http.Request) { r.AddCookie(incorr.NewCookieFromToken(token, 3600)) }, true},
+		{"header without scheme", func(r *http.Request) { r.Header.Set("X-Api-Token", token) }, true},
+		{"none", func(r *http.Request) {}, true},
+	}
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+			c.set(r)
+
+			got, errs := incorr.DecodeToken(r)
+			if (errs != nil) != c.wantErr {
+				t.Fatalf("DecodeToken() errs = %v, wantErr %v", errs, c.wantErr)
+			}
+			if !c.wantErr && got.StringIfAny(0) != "alice" {
+				t.Errorf("DecodeToken() got %q, want %q", got.StringIfAny(0), "alice")
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// maxFlags is the number of flags stored in a single value (uint64).
+const maxFlags = 64
+
+// Get / Set for Flags: up to 64 booleans within a single value.
+// The bitset is encoded as an Uint64 (minimal length):
+// the flags 0-7 take one byte, the flags 8-15 take two bytes…
+
+// Flags returns the bitset stored at the given key.
+// A key without value returns zero (all flags cleared).
+func (tv TValues) Flags(key int) (uint64, error) {
+	if key >= 0 && key >= len(tv.Values) {
+		return 0, nil
+	}
+	return tv.Uint64(key)
+}
+
+// SetFlags sets the bits of the mask, keeping the other bits unchanged.
+func (tv *TValues) SetFlags(key int, mask uint64) error {
+	flags, err := tv.Flags(key)
+	if err != nil {
+		return err
+	}
+	return tv.SetUint64(key, flags|mask)
+}
+
+// ClearFlags clears the bits of the mask, keeping the other bits unchanged.
+func (tv *TValues) ClearFlags(key int, mask uint64) error {
+	flags, err := tv.Flags(key)
+	if err != nil {
+		return err
+	}
+	return tv.SetUint64(key, flags&^mask)
+}
+
+// HasFlag returns true if the bit (0-63) is set in the bitset stored at the given key.
+func (tv TValues) HasFlag(key int, bit uint) bool {
+	if bit >= maxFlags {
+		return false
+	}
+	flags, err := tv.Flags(key)
+	return err == nil && (flags&(1<<bit)) != 0
+}
+
+// RequireFlags requires all the bits of the mask to be set in the bitset stored at the given key.
+func RequireFlags(key int, mask uint64) Predicate {
+	return func(tv TValues) error {
+		flags, err := tv.Flags(key)
+		if err != nil {
+			return fmt.Errorf("%w: key=%d %w", ReasonInsufficientScope, key, err)
+		}
+		if missing := mask &^ flags; missing != 0 {
+			return fmt.Errorf("%w: key=%d lacks flag #%d", ReasonInsufficientScope, key, bits.TrailingZeros64(missing))
+		}
+		return nil
+	}
+}
+
+// Permissions is a registry mapping permission names (e.g. "billing:write")
+// to the bit positions of the bitset stored at a given key.
+// The bit positions depend on the registration order:
+// append the new permissions at the end to keep the issued tokens valid.
+type Permissions struct {
+	key   int
+	names []string
+	bits  map[string]uint
+}
+
+// NewPermissions registers the permission names (up to 64)
+// stored in the bitset at the given key.
+func NewPermissions(key int, names ...string) *Permissions {
+	if err := checkWrite(key); err != nil {
+		log.Panic("NewPermissions", err)
+	}
+	if len(names) > maxFlags {
+		log.Panicf("NewPermissions: %d names exceed the max=%d", len(names), maxFlags)
+	}
+
+	p := &Permissions{key: key, names: append([]string(nil), names...), bits: make(map[string]uint, len(names))}
+	for i, n := range names {
+		if _, ok := p.bits[n]; ok {
+			log.Panicf("NewPermissions: duplicated name %q", n)
+		}
+		p.bits[n] = uint(i)
+	}
+	return p
+}
+
+// Mask returns the bitset corresponding to the permission names.
+func (p *Permissions) Mask(names ...string) (uint64, error) {
+	var mask uint64
+	for _, n := range names {
+		bit, ok := p.bits[n]
+		if !ok {
+			return 0, fmt.Errorf("unknown permission %q", n)
+		}
+		mask |= 1 << bit
+	}
+	return mask, nil
+}
+
+// Grant adds the permissions to the token values.
+func (p *Permissions) Grant(tv *TValues, names ...string) error {
+	mask, err := p.Mask(names...)
+	if err != nil {
+		return err
+	}
+	return tv.SetFlags(p.key, mask)
+}
+
+// Revoke removes the permissions from the token values.
+func (p *Permissions) Revoke(tv *TValues, names ...string) error {
+	mask, err := p.Mask(names...)
+	if err != nil {
+		return err
+	}
+	return tv.ClearFlags(p.key, mask)
+}
+
+// Has returns true if the token values grant the permission.
+func (p *Permissions) Has(tv TValues, name string) bool {
+	bit, ok := p.bits[name]
+	return ok && tv.HasFlag(p.key, bit)
+}
+
+// Names returns the permissions granted by the token values.
+func (p *Permissions) Names(tv TValues) []string {
+	flags, err := tv.Flags(p.key)
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for i, n := range p.names {
+		if flags&(1<<uint(i)) != 0 {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// Require requires all the permissions (see Incorruptible.Require).
+// Require panics if a permission name is unknown.
+func (p *Permissions) Require(names ...string) Predicate {
+	mask, err := p.Mask(names...)
+	if err != nil {
+		log.Panic("Permissions.Require", err)
+	}
+
+	return func(tv TValues) error {
+		flags, err := tv.Flags(p.key)
+		if err != nil {
+			return fmt.Errorf("%w: key=%d %w", ReasonInsufficientScope, p.key, err)
+		}
+		if missing := mask &^ flags; missing != 0 {
+			return fmt.Errorf("%w: lacks permission %q",
+				ReasonInsufficientScope, p.names[bits.TrailingZeros64(missing)])
+		}
+		return nil
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func TestFlags(t *testing.T) {
+	t.Parallel()
+
+	const keyFlags = 2
+
+	tv := incorruptible.EmptyTValues()
+	if tv.HasFlag(keyFlags, 3) {
+		t.Error("HasFlag() on missing value must be false")
+	}
+
+	if err := tv.SetFlags(keyFlags, 1<<3|1<<5); err != nil {
+		t.Fatal("SetFlags() error", err)
+	}
+	if err := tv.SetFlags(keyFlags, 1<<9); err != nil {
+		t.Fatal("SetFlags() error", err)
+	}
+	if err := tv.ClearFlags(keyFlags, 1<<5); err != nil {
+		t.Fatal("ClearFlags() error", err)
+	}
+
+	for bit, want := range map[uint]bool{3: true, 5: false, 9: true, 63: false, 64: false} {
+		if got := tv.HasFlag(keyFlags, bit); got != want {
+			t.Errorf("HasFlag(%d) = %v, want %v", bit, got, want)
+		}
+	}
+
+	// minimal-length encoding: 10 flags fit in two bytes
+	if n := len(tv.Values[keyFlags]); n != 2 {
+		t.Errorf("bitset length = %d bytes, want 2", n)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	t.Parallel()
+
+	perms := incorruptible.NewPermissions(1, "billing:read", "billing:write", "users:admin")
+
+	tv := incorruptible.EmptyTValues()
+	if err := perms.Grant(&tv, "billing:read", "users:admin"); err != nil {
+		t.Fatal("Grant() error", err)
+	}
+	if err := perms.Grant(&tv, "unknown"); err == nil {
+		t.Error("Grant() must reject an unknown permission")
+	}
+	if err := perms.Revoke(&tv, "users:admin"); err != nil {
+		t.Fatal("Revoke() error", err)
+	}
+
+	if got := perms.Names(tv); !reflect.DeepEqual(got, []string{"billing:read"}) {
+		t.Errorf("Names() = %v", got)
+	}
+	if !perms.Has(tv, "billing:read") || perms.Has(tv, "billing:write") {
+		t.Error("Has() mismatch")
+	}
+
+	if err := perms.Require("billing:read")(tv); err != nil {
+		t.Error("Require(billing:read) error", err)
+	}
+	err := perms.Require("billing:read", "billing:write")(tv)
+	if incorruptible.ReasonOf(err) != incorruptible.ReasonInsufficientScope {
+		t.Errorf("Require(billing:write) error = %v, want insufficient_scope", err)
+	}
+}
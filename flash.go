@@ -0,0 +1,184 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	// FlashMaxAge is the lifetime (in seconds) of the flash cookie:
+	// the flash messages are read by the next request (Post/Redirect/Get).
+	FlashMaxAge = 60
+
+	// FlashMaxSize is the maximum size of the flash cookie (name plus value).
+	FlashMaxSize = 2000
+
+	// MaxFlashes is the maximum number of queued flash messages.
+	MaxFlashes = MaxValues / 2
+
+	// maxFlashLen is the maximum length of the kind and message (maximum size of a value, see Marshal).
+	maxFlashLen = 255
+
+	// flashSuffix is appended to the session cookie name to form the flash cookie name.
+	flashSuffix = "-flash"
+)
+
+// flashAD is the additional data binding the token to the flash cookie:
+// a session token cannot be used as a flash cookie and vice versa.
+//
+//nolint:gochecknoglobals // constant
+var flashAD = []byte("flash")
+
+// ErrFlashTooLarge means the flash message does not fit in the flash cookie.
+var ErrFlashTooLarge = errors.New("flash messages exceed the flash cookie capacity")
+
+// Flash is a one-shot message displayed by the next page (e.g. "Profile saved").
+type Flash struct {
+	Kind    string // e.g. "info", "success", "error"
+	Message string
+}
+
+// AddFlash queues a flash message within a separate short-lived encrypted cookie
+// (the session cookie name suffixed by "-flash", expiring after FlashMaxAge).
+// The messages queued by the previous requests, and not yet read, are kept.
+// AddFlash can be called several times before writing the response header.
+// The returned error wraps ErrFlashTooLarge when the message exceeds 255 bytes,
+// when the cookie exceeds FlashMaxSize or when MaxFlashes messages are already queued:
+// the message is then discarded.
+func (incorr *Incorruptible) AddFlash(w http.ResponseWriter, r *http.Request, kind, msg string) error {
+	cookie := incorr.flashCookie(r)
+
+	flashes, pending := incorr.pendingFlashes(w, cookie.Name)
+	if !pending {
+		flashes = incorr.requestFlashes(r, cookie.Name)
+	}
+
+	if len(kind) > maxFlashLen || len(msg) > maxFlashLen {
+		return fmt.Errorf("%w: message length %d > max=%d", ErrFlashTooLarge, max(len(kind), len(msg)), maxFlashLen)
+	}
+	if len(flashes) >= MaxFlashes {
+		return fmt.Errorf("%w: max=%d messages", ErrFlashTooLarge, MaxFlashes)
+	}
+	flashes = append(flashes, Flash{Kind: kind, Message: msg})
+
+	tv, err := NewTValues()
+	if err != nil {
+		return err
+	}
+	for i, f := range flashes {
+		err = tv.Set(String(2*i, f.Kind), String(2*i+1, f.Message))
+		if err != nil {
+			return err
+		}
+	}
+	tv.SetExpiry(FlashMaxAge)
+
+	// no spill to the SessionStore: the flash messages require no server state
+	token, err := incorr.encodeMagic(tv, incorr.magic, incorr.scopedAD(flashAD))
+	if err != nil {
+		return err
+	}
+	cookie.Value = incorr.tokenScheme + token
+	if len(cookie.Name)+len(cookie.Value) > FlashMaxSize {
+		return fmt.Errorf("%w: %d bytes > max=%d", ErrFlashTooLarge, len(cookie.Name)+len(cookie.Value), FlashMaxSize)
+	}
+
+	removeSetCookie(w, cookie.Name)
+	http.SetCookie(w, &cookie)
+	return nil
+}
+
+// Flashes returns the flash messages queued by the previous requests
+// and deletes the flash cookie in the same response.
+// Flashes must be called before writing the response header.
+func (incorr *Incorruptible) Flashes(w http.ResponseWriter, r *http.Request) []Flash {
+	cookie := incorr.flashCookie(r)
+	if _, err := r.Cookie(cookie.Name); err != nil {
+		return nil
+	}
+
+	flashes := incorr.requestFlashes(r, cookie.Name)
+
+	cookie.MaxAge = -1 // MaxAge<0 means "delete cookie now"
+	incorr.setExpires(&cookie)
+	removeSetCookie(w, cookie.Name)
+	http.SetCookie(w, &cookie)
+	return flashes
+}
+
+// flashCookie derives the flash cookie from the cookie profile matching the request.
+func (incorr *Incorruptible) flashCookie(r *http.Request) http.Cookie {
+	base, _ := incorr.profile(r)
+	cookie := *base // local copy of the default cookie
+	cookie.Name += flashSuffix
+	cookie.Value = ""
+	cookie.MaxAge = FlashMaxAge
+	incorr.setExpires(&cookie)
+	return cookie
+}
+
+// requestFlashes decodes the flash cookie of the request.
+// The invalid or expired flash cookies are ignored.
+func (incorr *Incorruptible) requestFlashes(r *http.Request, name string) []Flash {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return nil
+	}
+	return incorr.decodeFlashes(cookie.Value)
+}
+
+// pendingFlashes decodes the flash cookie already set in the response header.
+// pending is true when the response header contains a flash cookie (even a deleted one).
+func (incorr *Incorruptible) pendingFlashes(w http.ResponseWriter, name string) (flashes []Flash, pending bool) {
+	for _, line := range w.Header().Values("Set-Cookie") {
+		c, err := http.ParseSetCookie(line)
+		if err == nil && c.Name == name {
+			flashes, pending = incorr.decodeFlashes(c.Value), true
+		}
+	}
+	return flashes, pending
+}
+
+func (incorr *Incorruptible) decodeFlashes(value string) []Flash {
+	token, err := incorr.trimTokenScheme(value)
+	if err != nil {
+		incorr.log.Debug("Ignore flash cookie", AttrError, err)
+		return nil
+	}
+
+	tv, err := incorr.decodeAD(token, incorr.scopedAD(flashAD))
+	if err != nil || !tv.ValidExpiry() {
+		incorr.log.Debug("Ignore flash cookie", AttrError, err)
+		return nil
+	}
+
+	flashes := make([]Flash, 0, len(tv.Values)/2)
+	for i := 0; i+1 < len(tv.Values); i += 2 {
+		flashes = append(flashes, Flash{Kind: string(tv.Values[i]), Message: string(tv.Values[i+1])})
+	}
+	return flashes
+}
+
+// removeSetCookie removes the cookie from the "Set-Cookie" response header.
+func removeSetCookie(w http.ResponseWriter, name string) {
+	lines := w.Header().Values("Set-Cookie")
+	kept := lines[:0:0]
+	for _, line := range lines {
+		if c, err := http.ParseSetCookie(line); err != nil || c.Name != name {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) == len(lines) {
+		return
+	}
+	w.Header().Del("Set-Cookie")
+	for _, line := range kept {
+		w.Header().Add("Set-Cookie", line)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func TestFlashes(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com")
+
+	// POST: queue two messages
+	r := httptest.NewRequest(http.MethodPost, "https://example.com/profile", http.NoBody)
+	w := httptest.NewRecorder()
+	if err := incorr.AddFlash(w, r, "success", "Profile saved"); err != nil {
+		t.Fatal("AddFlash() error", err)
+	}
+	if err := incorr.AddFlash(w, r, "info", "Check your email"); err != nil {
+		t.Fatal("AddFlash() error", err)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want a single flash cookie", len(cookies))
+	}
+	flash := cookies[0]
+	if !strings.HasSuffix(flash.Name, "-flash") || flash.MaxAge != incorruptible.FlashMaxAge || !flash.HttpOnly {
+		t.Errorf("unexpected flash cookie %v", flash)
+	}
+
+	// GET: read and clear
+	r = httptest.NewRequest(http.MethodGet, "https://example.com/profile", http.NoBody)
+	r.AddCookie(flash)
+	w = httptest.NewRecorder()
+	got := incorr.Flashes(w, r)
+
+	want := []incorruptible.Flash{{"success", "Profile saved"}, {"info", "Check your email"}}
+	if len(got) != len(want) {
+		t.Fatalf("Flashes() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Flashes() = %v, want %v", got, want)
+		}
+	}
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].Name != flash.Name || c[0].MaxAge >= 0 {
+		t.Errorf("Flashes() must delete the flash cookie, got %v", c)
+	}
+
+	// no flash cookie
+	r = httptest.NewRequest(http.MethodGet, "https://example.com/profile", http.NoBody)
+	w = httptest.NewRecorder()
+	if got = incorr.Flashes(w, r); len(got) != 0 || len(w.Result().Cookies()) != 0 {
+		t.Errorf("Flashes() = %v with cookies %v, want none", got, w.Result().Cookies())
+	}
+}
+
+func TestFlashes_Rejected(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com")
+
+	// a session token is not accepted as flash cookie
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	session, _, err := incorr.NewCookie(r, incorruptible.String(0, "success"), incorruptible.String(1, "forged"))
+	if err != nil {
+		t.Fatal("NewCookie() error", err)
+	}
+	r.AddCookie(&http.Cookie{Name: session.Name + "-flash", Value: session.Value})
+	if got := incorr.Flashes(httptest.NewRecorder(), r); len(got) != 0 {
+		t.Errorf("Flashes() = %v, want none", got)
+	}
+
+	// size cap
+	r = httptest.NewRequest(http.MethodPost, "https://example.com/", http.NoBody)
+	w := httptest.NewRecorder()
+	if err = incorr.AddFlash(w, r, "error", strings.Repeat("x", incorruptible.FlashMaxSize)); !errors.Is(err, incorruptible.ErrFlashTooLarge) {
+		t.Errorf("AddFlash() error %v, want ErrFlashTooLarge", err)
+	}
+
+	// cookie size cap (random messages are not compressible)
+	w = httptest.NewRecorder()
+	err = nil
+	for i := 0; err == nil && i < incorruptible.MaxFlashes; i++ {
+		buf := make([]byte, 120)
+		_, _ = rand.Read(buf)
+		err = incorr.AddFlash(w, r, "info", hex.EncodeToString(buf))
+	}
+	if !errors.Is(err, incorruptible.ErrFlashTooLarge) {
+		t.Errorf("AddFlash() error %v, want ErrFlashTooLarge", err)
+	}
+
+	// max number of messages
+	w = httptest.NewRecorder()
+	for i := range incorruptible.MaxFlashes {
+		if err = incorr.AddFlash(w, r, "info", "msg"); err != nil {
+			t.Fatalf("AddFlash() #%d error %v", i, err)
+		}
+	}
+	if err = incorr.AddFlash(w, r, "info", "one more"); !errors.Is(err, incorruptible.ErrFlashTooLarge) {
+		t.Errorf("AddFlash() error %v, want ErrFlashTooLarge", err)
+	}
+}
+
+func TestFlashes_SchemeCase(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com")
+
+	r := httptest.NewRequest(http.MethodPost, "https://example.com/profile", http.NoBody)
+	w := httptest.NewRecorder()
+	if err := incorr.AddFlash(w, r, "success", "Profile saved"); err != nil {
+		t.Fatal("AddFlash() error", err)
+	}
+
+	// the token scheme is case-insensitive (RFC 3986) like for the session cookie
+	flash := w.Result().Cookies()[0]
+	flash.Value = strings.ToUpper(incorruptible.DefaultTokenScheme) + strings.TrimPrefix(flash.Value, incorruptible.DefaultTokenScheme)
+
+	r = httptest.NewRequest(http.MethodGet, "https://example.com/profile", http.NoBody)
+	r.AddCookie(flash)
+	if got := incorr.Flashes(httptest.NewRecorder(), r); len(got) != 1 || got[0].Message != "Profile saved" {
+		t.Errorf("Flashes() = %v, want the flash message", got)
+	}
+}
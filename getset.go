@@ -276,7 +276,7 @@ func (tv *TValues) set(key int, buf []byte) {
 	}
 
 	if key >= cap(tv.Values) {
-		values := make([][]byte, MaxValues+1)
+		values := make([][]byte, len(tv.Values), MaxValues+1)
 		copy(values, tv.Values)
 		tv.Values = values
 	}
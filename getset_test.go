@@ -292,3 +292,26 @@ var dataCases = []struct {
 	{"i=31 cap=5", incorruptible.MaxValues, 9, false, incorruptible.TValues{Values: make([][]byte, 0, 5)}},
 	{"i=32 cap=5", incorruptible.MaxValues + 1, 9, true, incorruptible.TValues{Values: make([][]byte, 0, 5)}},
 }
+
+func TestTValues_SetGrow(t *testing.T) {
+	t.Parallel()
+
+	tv, err := incorruptible.NewTValues(incorruptible.String(0, "a"))
+	if err != nil {
+		t.Fatal("NewTValues() error", err)
+	}
+
+	// a key beyond the capacity must not extend the values up to MaxValues
+	if err = tv.Set(incorruptible.Uint64(5, 7)); err != nil {
+		t.Fatal("Set() error", err)
+	}
+	if len(tv.Values) != 6 {
+		t.Errorf("len(Values)=%d, want 6", len(tv.Values))
+	}
+	if s, _ := tv.String(0); s != "a" {
+		t.Errorf("String(0)=%q, want a", s)
+	}
+	if v, _ := tv.Uint64(5); v != 7 {
+		t.Errorf("Uint64(5)=%d, want 7", v)
+	}
+}
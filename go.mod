@@ -1,6 +1,6 @@
 module github.com/teal-finance/incorruptible
 
-go 1.22
+go 1.23
 
 require (
 	github.com/klauspost/compress v1.17.9
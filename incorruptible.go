@@ -9,6 +9,7 @@ import (
 	"crypto/cipher"
 	crand "crypto/rand"
 	"encoding/binary"
+	"fmt"
 	"math/rand"
 	"net/http"
 	"net/url"
@@ -17,32 +18,70 @@ import (
 
 	// baseN "github.com/teal-finance/BaseXX/base92" // use another package with same interface.
 	baseN "github.com/mtraver/base91"
-
-	"github.com/teal-finance/emo"
 )
 
-//nolint:gochecknoglobals // global logger
-var log = emo.NewZone("incorr")
-
 type Incorruptible struct {
-	writeErr WriteErr
-	SetIP    bool // If true => put the remote IP in the token.
-	cookie   http.Cookie
-	cipher   cipher.AEAD
-	magic    byte
-	baseN    *baseN.Encoding
+	writeErr    WriteErr
+	log         *logger
+	observer    Observer
+	audit       AuditSink
+	loginURL    *url.URL
+	defaultPage string
+	SetIP       bool // If true => put the remote IP in the token.
+	cookie      http.Cookie
+	cipher      cipher.AEAD
+	magic       byte
+	baseN       *baseN.Encoding
+	extractors  []TokenExtractor
+
+	authScheme  string
+	tokenScheme string
+	realm       string
+	mode        Mode
+
+	store  SessionStore
+	budget int
+
+	attrs      cookieAttrs
+	profiles   []cookieProfile
+	fallback   HostFallback
+	trustProxy bool
+
+	origins    []string
+	csrfKey    int
+	csrfExempt []string
+	csrfCookie string
+	navRoutes  []string
+	corsRoutes []string
+
+	authTimeKey int
+
+	tokenIDKey  int
+	carryOver   []int
+	revocations RevocationStore
+	migrate     MigrateFunc
+
+	minimalist bool
+	visitorKey int
+
+	clock func() time.Time // clock of the signed URLs, time.Now when nil
+
+	urls    []*url.URL
+	ad      []byte              // additional data separating the token types (see Profile)
+	names   map[string]struct{} // profile names, shared by the profiles
+	cookies map[string]struct{} // cookie names (including flash and chunks), shared by the profiles
 }
 
 const (
-	authScheme   = "Bearer "
-	tokenScheme  = "i:" // See RFC 8959, here "i" means "incorruptible token format"
-	prefixScheme = authScheme + tokenScheme
+	DefaultAuthScheme  = "Bearer"
+	DefaultTokenScheme = "i:" // See RFC 8959, here "i" means "incorruptible token format"
 )
 
 // New creates a new Incorruptible. The order of the parameters are consistent with garcon.NewJWTChecker (see Teal-Finance/Garcon).
 // The Garcon middleware constructors use a garcon.Writer as first parameter.
 // Please share your thoughts/feedback, we can still change that.
-func New(writeErr WriteErr, urls []*url.URL, secretKey []byte, cookieName string, maxAge int, setIP bool) *Incorruptible {
+// The optional parameters customize the default settings, see Option.
+func New(writeErr WriteErr, urls []*url.URL, secretKey []byte, cookieName string, maxAge int, setIP bool, opts ...Option) *Incorruptible {
 	if writeErr == nil {
 		writeErr = defaultWriteErr
 	}
@@ -51,8 +90,6 @@ func New(writeErr WriteErr, urls []*url.URL, secretKey []byte, cookieName string
 		log.Panic("No URL => Cannot set cookie attributes: Domain, Secure and Path")
 	}
 
-	secure, dns, dir := extractMainDomain(urls[0])
-
 	cipher := NewCipher(secretKey)
 
 	// initialize the random generator with a reproducible secret seed
@@ -64,21 +101,101 @@ func New(writeErr WriteErr, urls []*url.URL, secretKey []byte, cookieName string
 	resetRandomGenerator(nil)
 
 	incorr := Incorruptible{
-		writeErr: writeErr,
-		SetIP:    setIP,
-		cookie:   newCookie(cookieName, secure, dns, dir, maxAge),
-		cipher:   cipher,
-		magic:    magic,
-		baseN:    baseN.NewEncoding(encodingAlphabet),
+		writeErr:   writeErr,
+		log:        log,
+		SetIP:      setIP,
+		cipher:     cipher,
+		magic:      magic,
+		baseN:      baseN.NewEncoding(encodingAlphabet),
+		extractors: defaultExtractors(),
+
+		authScheme:  DefaultAuthScheme,
+		tokenScheme: DefaultTokenScheme,
+		realm:       urls[0].Host,
+
+		attrs: defaultCookieAttrs(),
+
+		origins: originsOf(urls),
+		csrfKey: -1, // disabled
+
+		authTimeKey: -1, // disabled
+		tokenIDKey:  -1, // disabled
+		visitorKey:  -1, // disabled
+
+		urls:    urls,
+		names:   map[string]struct{}{},
+		cookies: map[string]struct{}{},
+	}
+	incorr.cookie.Name = cookieName
+	incorr.cookie.MaxAge = maxAge
+
+	incorr.apply(opts)
+	incorr.setup()
+	return &incorr
+}
+
+// setup checks the options and derives the cookie profiles from the URLs.
+func (incorr *Incorruptible) setup() {
+	incorr.checkMode(incorr.urls)
+
+	if incorr.revocations != nil && incorr.tokenIDKey < 0 {
+		log.Panic("WithRevocationStore requires WithTokenID")
+	}
+	if incorr.minimalist && incorr.visitorKey >= 0 {
+		log.Panic("WithMinimalistToken is incompatible with WithVisitorID")
+	}
+	incorr.checkKeys()
+
+	// the stores log through the configured logger
+	for _, store := range []any{incorr.store, incorr.revocations} {
+		if s, ok := store.(interface{ setLogger(l *logger) }); ok {
+			s.setLogger(incorr.log)
+		}
+	}
+
+	var err error
+	incorr.profiles, err = newProfiles(incorr.log, incorr.cookie.Name, incorr.cookie.MaxAge, incorr.urls, incorr.attrs)
+	if err != nil {
+		log.Panic("Cookie attributes: ", err)
 	}
+	incorr.cookie = incorr.profiles[0].cookie
+	incorr.reserveCookieNames()
+	incorr.warnUntrustedProxy()
 
 	incorr.addMinimalistToken()
 
-	log.Securityf("Cookie %s Domain=%v Path=%v Max-Age=%v Secure=%v SameSite=%v HttpOnly=%v Value=%d bytes",
-		incorr.cookie.Name, incorr.cookie.Domain, incorr.cookie.Path, incorr.cookie.MaxAge,
-		incorr.cookie.Secure, incorr.cookie.SameSite, incorr.cookie.HttpOnly, len(incorr.cookie.Value))
+	for _, p := range incorr.profiles {
+		incorr.log.Security("Cookie", "origin", p.scheme+"://"+p.host, "name", p.cookie.Name,
+			"domain", p.cookie.Domain, "path", p.cookie.Path, "max_age", p.cookie.MaxAge,
+			"secure", p.cookie.Secure, "same_site", int(p.cookie.SameSite), "http_only", p.cookie.HttpOnly,
+			"partitioned", p.cookie.Partitioned, "value_bytes", len(p.cookie.Value))
+	}
+}
+
+// checkKeys panics when two options reserve the same key
+// or when a reserved key cannot be stored in a token (at most MaxValues values).
+func (incorr *Incorruptible) checkKeys() {
+	owners := map[int]string{}
+	reserve := func(option string, key int) {
+		if key < 0 {
+			return // disabled
+		}
+		if key >= MaxValues {
+			log.Panicf("%s(%d) requires a key below MaxValues=%d", option, key, MaxValues)
+		}
+		if other, ok := owners[key]; ok && other != option {
+			log.Panicf("%s(%d) collides with %s(%d)", option, key, other, key)
+		}
+		owners[key] = option
+	}
 
-	return &incorr
+	reserve("WithCSRF", incorr.csrfKey)
+	reserve("WithAuthTimeKey", incorr.authTimeKey)
+	reserve("WithTokenID", incorr.tokenIDKey)
+	reserve("WithVisitorID", incorr.visitorKey)
+	for _, k := range incorr.carryOver {
+		reserve("WithCarryOver", k)
+	}
 }
 
 func (incorr *Incorruptible) addMinimalistToken() {
@@ -93,17 +210,20 @@ func (incorr *Incorruptible) addMinimalistToken() {
 		log.Panic(err)
 	}
 
-	// insert this generated token in the cookie
-	incorr.cookie.Value = tokenScheme + token
+	// insert this generated token in the cookies
+	incorr.cookie.Value = incorr.tokenScheme + token
+	for i := range incorr.profiles {
+		incorr.profiles[i].cookie.Value = incorr.cookie.Value
+	}
 }
 
 func (incorr *Incorruptible) useMinimalistToken() bool {
-	return (incorr.cookie.MaxAge <= 0) && (!incorr.SetIP)
+	return incorr.minimalist && (incorr.cookie.MaxAge <= 0) && (!incorr.SetIP)
 }
 
 // equalMinimalistToken compares with the default token.
 func (incorr *Incorruptible) equalMinimalistToken(base91 string) bool {
-	const schemeSize = len(tokenScheme) // to skip the token scheme
+	schemeSize := len(incorr.tokenScheme) // to skip the token scheme
 	return incorr.useMinimalistToken() && (base91 == incorr.cookie.Value[schemeSize:])
 }
 
@@ -136,9 +256,18 @@ func shuffle(s string) string {
 }
 
 // NewCookie creates a new cookie based on default values.
-// the HTTP request parameter is used to get the remote IP (only when incorr.SetIP is true).
+// the HTTP request parameter is used to select the cookie profile matching
+// the request host and scheme (see WithHostFallback),
+// and to get the remote IP (only when incorr.SetIP is true).
+// The request may be nil when SetIP is false.
+// The cookie of a large token may exceed MaxCookieSize:
+// use SetCookie or NewCookies to split it across several cookies.
 func (incorr *Incorruptible) NewCookie(r *http.Request, keyValues ...KVal) (*http.Cookie, TValues, error) {
-	cookie := incorr.cookie // local copy of the default cookie
+	base, err := incorr.profile(r)
+	cookie := *base // local copy of the default cookie
+	if err != nil {
+		return &cookie, TValues{}, err
+	}
 
 	tv, err := incorr.NewTValues(r)
 	if err != nil {
@@ -156,12 +285,16 @@ func (incorr *Incorruptible) NewCookie(r *http.Request, keyValues ...KVal) (*htt
 			return &cookie, tv, err
 		}
 
-		cookie.Value = tokenScheme + token
+		cookie.Value = incorr.tokenScheme + token
+		incorr.tokenIssued() // the shared minimalist token is not counted
 	}
 
+	incorr.setExpires(&cookie)
 	return &cookie, tv, nil
 }
 
+// NewTValues returns the values of a new token: the expiry, the remote IP (when SetIP is true),
+// a new visitor ID (see WithVisitorID), a new token ID (see WithTokenID) and the keyValues.
 func (incorr *Incorruptible) NewTValues(r *http.Request, keyValues ...KVal) (TValues, error) {
 	var tv TValues
 
@@ -175,7 +308,17 @@ func (incorr *Incorruptible) NewTValues(r *http.Request, keyValues ...KVal) (TVa
 		}
 	}
 
-	err := tv.Set(keyValues...)
+	err := incorr.setVisitorID(&tv)
+	if err != nil {
+		return tv, err
+	}
+
+	err = incorr.setTokenID(&tv)
+	if err != nil {
+		return tv, err
+	}
+
+	err = tv.Set(keyValues...)
 	return tv, err
 }
 
@@ -185,18 +328,28 @@ func (incorr *Incorruptible) NewCookieFromValues(tv TValues) (*http.Cookie, erro
 		return &incorr.cookie, err
 	}
 	cookie := incorr.NewCookieFromToken(token, tv.MaxAge())
+	incorr.tokenIssued()
 	return cookie, nil
 }
 
 func (incorr *Incorruptible) NewCookieFromToken(token string, maxAge int) *http.Cookie {
-	cookie := incorr.cookie
-	cookie.Value = tokenScheme + token
+	return incorr.cookieFromToken(&incorr.cookie, token, maxAge)
+}
+
+func (incorr *Incorruptible) cookieFromToken(base *http.Cookie, token string, maxAge int) *http.Cookie {
+	cookie := *base // local copy of the default cookie
+	cookie.Value = incorr.tokenScheme + token
 	cookie.MaxAge = maxAge
+	incorr.setExpires(&cookie)
 	return &cookie
 }
 
 // DeadCookie returns an Incorruptible cookie without Value and with "Max-Age=0"
 // in order to delete the Incorruptible cookie in the current HTTP session.
+// DeadCookie returns the cookie of the first URL, use DeadCookieFor
+// to select the cookie matching the request.
+// DeadCookie does not delete the chunks of a large token (see MaxCookieSize),
+// use DeadCookies to delete the main cookie and every chunk sent by the request.
 //
 // Example:
 //
@@ -204,9 +357,19 @@ func (incorr *Incorruptible) NewCookieFromToken(token string, maxAge int) *http.
 //	    http.SetCookie(w, Incorruptible.DeadCookie())
 //	}
 func (incorr *Incorruptible) DeadCookie() *http.Cookie {
-	cookie := incorr.cookie // local copy of the default cookie
+	return incorr.DeadCookieFor(nil)
+}
+
+// DeadCookieFor is like DeadCookie but selects the cookie profile (Name, Domain, Path)
+// matching the request host and scheme (see WithHostFallback).
+// The request may be nil to select the cookie of the first URL.
+// Like DeadCookie, DeadCookieFor does not delete the chunks, see DeadCookies.
+func (incorr *Incorruptible) DeadCookieFor(r *http.Request) *http.Cookie {
+	base, _ := incorr.profile(r) // delete the cookie even if the host is unknown
+	cookie := *base              // local copy of the default cookie
 	cookie.Value = ""
 	cookie.MaxAge = -1 // MaxAge<0 means "delete cookie now"
+	incorr.setExpires(&cookie)
 	return &cookie
 }
 
@@ -245,21 +408,7 @@ func extractMainDomain(u *url.URL) (secure bool, dns, dir string) {
 	return secure, u.Hostname(), u.Path
 }
 
-// This function was used to trigger the dev. mode
-// func isLocalhost(urls []*url.URL) bool {
-// 	if len(urls) > 0 && urls[0].Scheme == "http" {
-// 		host, _, _ := net.SplitHostPort(urls[0].Host)
-// 		if host == "localhost" {
-// 			log.Security("DevMode accepts missing/invalid token from", urls[0])
-// 			return true
-// 		}
-// 	}
-//
-// 	log.Security("ProdMode requires valid token: no http://localhost in first of", urls)
-// 	return false
-// }
-
-func newCookie(name string, secure bool, dns, dir string, maxAge int) http.Cookie {
+func newCookie(name string, secure bool, dns, dir string, maxAge int, attrs cookieAttrs) (http.Cookie, error) {
 	dir = path.Clean(dir)
 	if dir == "." {
 		dir = "/"
@@ -275,37 +424,48 @@ func newCookie(name string, secure bool, dns, dir string, maxAge int) http.Cooki
 		}
 	}
 
-	// cookie prefix for enhanced security
-	if secure && name[0] != '_' {
-		if dir == "/" {
-			// "__Host-" when cookie has "Secure" flag, has no "Domain",
-			// has "Path=/" and is sent from a secure origin.
-			dns = ""
-			name = "__Host-" + name
-		} else {
-			// "__Secure-" when cookie has "Secure" flag and is sent from a secure origin
-			// "__Host-" is better than the "__Secure-" prefix.
-			name = "__Secure-" + name
+	if attrs.domain != "" {
+		if !domainMatch(dns, attrs.domain) {
+			return http.Cookie{}, fmt.Errorf("Domain=%s does not match the host %s", attrs.domain, dns)
 		}
+		dns = attrs.domain
+	}
+
+	prefix, err := attrs.prefix.resolve(name, secure, dir, attrs.domain)
+	if err != nil {
+		return http.Cookie{}, err
 	}
 
-	// sameSite = Strict works when using two backends like:
-	// localhost:3000 (node) and localhost:8080 (API)
-	// https://developer.mozilla.org/docs/Web/HTTP/Headers/Set-Cookie/SameSite
-	const sameSite = http.SameSiteStrictMode
+	switch prefix {
+	case hostPrefix:
+		// "__Host-" when cookie has "Secure" flag, has no "Domain",
+		// has "Path=/" and is sent from a secure origin.
+		dns = ""
+		name = hostPrefix + name
+	case securePrefix:
+		// "__Secure-" when cookie has "Secure" flag and is sent from a secure origin
+		// "__Host-" is better than the "__Secure-" prefix.
+		name = securePrefix + name
+	}
 
-	return http.Cookie{
-		Name:       name,
-		Value:      "", // emptyCookie because no token
-		Path:       dir,
-		Domain:     dns,
-		Expires:    time.Time{},
-		RawExpires: "",
-		MaxAge:     maxAge,
-		Secure:     secure,
-		HttpOnly:   true,
-		SameSite:   sameSite,
-		Raw:        "",
-		Unparsed:   nil,
+	err = attrs.check(secure)
+	if err != nil {
+		return http.Cookie{}, err
 	}
+
+	return http.Cookie{
+		Name:        name,
+		Value:       "", // emptyCookie because no token
+		Path:        dir,
+		Domain:      dns,
+		Expires:     time.Time{},
+		RawExpires:  "",
+		MaxAge:      maxAge,
+		Secure:      secure,
+		HttpOnly:    true,
+		SameSite:    attrs.sameSite,
+		Partitioned: attrs.partitioned,
+		Raw:         "",
+		Unparsed:    nil,
+	}, nil
 }
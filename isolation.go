@@ -0,0 +1,117 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrCrossSite means the Isolate middleware rejected a cross-site request.
+var ErrCrossSite = errors.New("cross-site request rejected by resource isolation policy")
+
+// WithNavigationRoutes restricts the cross-site navigations accepted by Isolate
+// to the given paths (e.g. "/" and "/login"). By default, Isolate accepts
+// the cross-site top-level navigations (links from other sites) on all paths.
+// A path ending with a slash "/" also matches all its sub-paths.
+func WithNavigationRoutes(paths ...string) Option {
+	return func(incorr *Incorruptible) {
+		incorr.navRoutes = append(incorr.navRoutes, paths...)
+	}
+}
+
+// WithCORSRoutes exempts the CORS endpoints from Isolate:
+// these paths accept the cross-site requests from any origin.
+// A path ending with a slash "/" also matches all its sub-paths.
+func WithCORSRoutes(paths ...string) Option {
+	return func(incorr *Incorruptible) {
+		incorr.corsRoutes = append(incorr.corsRoutes, paths...)
+	}
+}
+
+// Isolate is a middleware implementing a resource isolation policy:
+// Isolate rejects the cross-site requests carrying the Incorruptible cookie
+// using the Fetch Metadata headers ("Sec-Fetch-Site", "Sec-Fetch-Mode", "Sec-Fetch-Dest")
+// and the "Origin" header for the browsers not sending the Fetch Metadata.
+// The allowed origins are the URLs passed to New and the trusted origins (see WithTrustedOrigins).
+// Exceptions: the top-level navigations (see WithNavigationRoutes)
+// and the CORS endpoints (see WithCORSRoutes).
+// The rejected requests are reported through WriteErr with "403 Forbidden".
+func (incorr *Incorruptible) Isolate(next http.Handler) http.Handler {
+	incorr.log.Security("Middleware", AttrEvent, "middleware", "name", "Isolate", "origins", incorr.origins,
+		"navigation", incorr.navRoutes, "cors", incorr.corsRoutes)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := incorr.VerifyIsolation(r); err != nil {
+			incorr.log.rejected("isolate", r, err)
+			incorr.writeErr(w, r, http.StatusForbidden, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// VerifyIsolation returns nil when Isolate accepts the request,
+// else the returned error wraps ErrCrossSite.
+func (incorr *Incorruptible) VerifyIsolation(r *http.Request) error {
+	base, _ := incorr.profile(r)
+	if _, err := r.Cookie(base.Name); err != nil {
+		return nil // no session cookie => nothing to protect
+	}
+
+	if matchPath(incorr.corsRoutes, r.URL.Path) {
+		return nil
+	}
+
+	origin := r.Header.Get("Origin")
+
+	switch site := r.Header.Get("Sec-Fetch-Site"); site {
+	case "same-origin", "same-site", "none":
+		return nil
+
+	case "":
+		// browser not supporting Fetch Metadata => rely on Origin
+		if origin == "" || incorr.allowedOrigin(origin) {
+			return nil
+		}
+		return fmt.Errorf("%w: Origin=%q", ErrCrossSite, origin)
+
+	default: // "cross-site"
+		if origin != "" && origin != "null" && incorr.allowedOrigin(origin) {
+			return nil
+		}
+		if incorr.isNavigation(r) {
+			return nil
+		}
+		return fmt.Errorf("%w: Sec-Fetch-Site=%q Sec-Fetch-Mode=%q Sec-Fetch-Dest=%q Origin=%q",
+			ErrCrossSite, site, r.Header.Get("Sec-Fetch-Mode"), r.Header.Get("Sec-Fetch-Dest"), origin)
+	}
+}
+
+// isNavigation returns true for a top-level navigation on an allowed route.
+// The navigations embedding the resource (object, embed) are not accepted.
+func (incorr *Incorruptible) isNavigation(r *http.Request) bool {
+	if r.Method != http.MethodGet || r.Header.Get("Sec-Fetch-Mode") != "navigate" {
+		return false
+	}
+	if dest := r.Header.Get("Sec-Fetch-Dest"); dest == "object" || dest == "embed" {
+		return false
+	}
+	return len(incorr.navRoutes) == 0 || matchPath(incorr.navRoutes, r.URL.Path)
+}
+
+// matchPath returns true when p is one of the paths
+// or a sub-path of one of the paths ending with a slash "/".
+func matchPath(paths []string, p string) bool {
+	for _, e := range paths {
+		if p == e || (strings.HasSuffix(e, "/") && strings.HasPrefix(p, e)) {
+			return true
+		}
+	}
+	return false
+}
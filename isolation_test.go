@@ -0,0 +1,81 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func TestIsolate(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com/",
+		incorruptible.WithNavigationRoutes("/home", "/login"),
+		incorruptible.WithCORSRoutes("/api/public/"))
+
+	cookie, _, err := incorr.NewCookie(nil)
+	if err != nil {
+		t.Fatal("NewCookie() error", err)
+	}
+
+	cases := []struct {
+		name       string
+		method     string
+		path       string
+		noCookie   bool
+		header     map[string]string
+		wantStatus int
+	}{
+		{"old browser", "POST", "/account", false, nil, http.StatusOK},
+		{"same-origin", "POST", "/account", false, map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+		{"user typed URL", "GET", "/account", false, map[string]string{"Sec-Fetch-Site": "none"}, http.StatusOK},
+		{"cross-site without cookie", "POST", "/account", true, map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusOK},
+		{"cross-site fetch", "POST", "/account", false, map[string]string{
+			"Sec-Fetch-Site": "cross-site", "Sec-Fetch-Mode": "cors", "Origin": "https://evil.org",
+		}, http.StatusForbidden},
+		{"cross-site navigation", "GET", "/login", false, map[string]string{
+			"Sec-Fetch-Site": "cross-site", "Sec-Fetch-Mode": "navigate", "Sec-Fetch-Dest": "document",
+		}, http.StatusOK},
+		{"cross-site navigation elsewhere", "GET", "/account", false, map[string]string{
+			"Sec-Fetch-Site": "cross-site", "Sec-Fetch-Mode": "navigate", "Sec-Fetch-Dest": "document",
+		}, http.StatusForbidden},
+		{"cross-site embed", "GET", "/login", false, map[string]string{
+			"Sec-Fetch-Site": "cross-site", "Sec-Fetch-Mode": "navigate", "Sec-Fetch-Dest": "embed",
+		}, http.StatusForbidden},
+		{"cross-site CORS route", "POST", "/api/public/items", false, map[string]string{
+			"Sec-Fetch-Site": "cross-site", "Sec-Fetch-Mode": "cors", "Origin": "https://partner.org",
+		}, http.StatusOK},
+		{"old browser foreign origin", "POST", "/account", false, map[string]string{"Origin": "https://evil.org"}, http.StatusForbidden},
+		{"old browser own origin", "POST", "/account", false, map[string]string{"Origin": "https://example.com"}, http.StatusOK},
+	}
+
+	handler := incorr.Isolate(okHandler)
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(c.method, "https://example.com"+c.path, http.NoBody)
+			for k, v := range c.header {
+				r.Header.Set(k, v)
+			}
+			if !c.noCookie {
+				r.AddCookie(cookie)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != c.wantStatus {
+				t.Errorf("status = %d, want %d body=%s", w.Code, c.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/teal-finance/emo"
+)
+
+// LevelSecurity is the slog level of the security events (between Info and Warn).
+const LevelSecurity = slog.LevelInfo + 2
+
+// Stable attribute names of the log records.
+const (
+	AttrEvent  = "event"  // security event: "reject", "csrf", "isolate", "middleware"…
+	AttrReason = "reason" // machine-readable Reason of the rejection
+	AttrMethod = "method" // HTTP method of the request
+	AttrPath   = "path"   // URL path of the request
+	AttrError  = "error"  // error message
+	AttrStage  = "stage"  // stage of the encode/decode pipeline (debug level)
+)
+
+// logger wraps a slog.Logger with the helpers used by this package.
+type logger struct {
+	*slog.Logger
+}
+
+//nolint:gochecknoglobals // default logger used when no Incorruptible is available
+var log = &logger{slog.New(&emoHandler{zone: emo.NewZone("incorr"), level: slog.LevelInfo})}
+
+// WithLogger sets the structured logger. Default is an adapter
+// to the emo logger "incorr" (level Info). A nil logger discards the logs.
+// Enable the level Debug to trace the stages of the encode/decode pipeline.
+// The construction errors are logged (level Error) by the default logger before panicking.
+func WithLogger(l *slog.Logger) Option {
+	return func(incorr *Incorruptible) {
+		if l == nil {
+			l = slog.New(discardHandler{})
+		}
+		incorr.log = &logger{l}
+	}
+}
+
+// Security logs a security event.
+func (l *logger) Security(msg string, args ...any) {
+	l.Log(context.Background(), LevelSecurity, msg, args...)
+}
+
+// Panic logs the message at level Error and panics.
+// The arguments are formatted as fmt.Sprintln does (without the final newline).
+func (l *logger) Panic(args ...any) {
+	msg := strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+	l.Error(msg)
+	panic(msg)
+}
+
+// Panicf logs the message at level Error and panics.
+func (l *logger) Panicf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	l.Error(msg)
+	panic(msg)
+}
+
+// rejected logs the rejection of a request as a security event.
+func (l *logger) rejected(event string, r *http.Request, err error) {
+	args := []any{AttrEvent, event, AttrMethod, r.Method, AttrPath, r.URL.Path}
+	var reason Reason
+	if errors.As(err, &reason) {
+		args = append(args, AttrReason, string(reason))
+	}
+	args = append(args, AttrError, err)
+	l.Security("Rejected request", args...)
+}
+
+// traceEnabled returns true when the Debug level is enabled.
+func (l *logger) traceEnabled() bool {
+	return l.Enabled(context.Background(), slog.LevelDebug)
+}
+
+// traceS traces a string of the encode/decode pipeline.
+func (l *logger) traceS(stage, s string) {
+	if l.traceEnabled() {
+		l.Debug("Trace", AttrStage, stage, "len", len(s), "head", s[:min(len(s), 30)])
+	}
+}
+
+// traceB traces a byte-buffer of the encode/decode pipeline.
+func (l *logger) traceB(stage string, buf []byte) {
+	if l.traceEnabled() {
+		l.Debug("Trace", AttrStage, stage, "len", len(buf), "cap", cap(buf), "head", fmt.Sprintf("%x", buf[:min(len(buf), 30)]))
+	}
+}
+
+// traceV traces TValues of the encode/decode pipeline.
+func (l *logger) traceV(stage string, tv TValues, err error) {
+	if l.traceEnabled() {
+		l.Debug("Trace", AttrStage, stage, "expires", tv.Expires, "ip", tv.IP, "values", len(tv.Values), AttrError, err)
+	}
+}
+
+// emoHandler is a slog.Handler forwarding the records to an emo zone.
+type emoHandler struct {
+	zone  emo.Zone
+	level slog.Level
+	attrs []slog.Attr
+	group string
+}
+
+func (h *emoHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *emoHandler) Handle(_ context.Context, rec slog.Record) error {
+	var b strings.Builder
+	b.WriteString(rec.Message)
+	for _, a := range h.attrs {
+		appendAttr(&b, h.group, a)
+	}
+	rec.Attrs(func(a slog.Attr) bool {
+		appendAttr(&b, h.group, a)
+		return true
+	})
+
+	switch {
+	case rec.Level >= slog.LevelError:
+		h.zone.Error(b.String())
+	case rec.Level >= slog.LevelWarn:
+		h.zone.Warning(b.String())
+	case rec.Level >= LevelSecurity:
+		h.zone.Security(b.String())
+	case rec.Level >= slog.LevelInfo:
+		h.zone.Info(b.String())
+	default:
+		h.zone.Debug(b.String())
+	}
+	return nil
+}
+
+func appendAttr(b *strings.Builder, group string, a slog.Attr) {
+	b.WriteByte(' ')
+	if group != "" {
+		b.WriteString(group)
+		b.WriteByte('.')
+	}
+	b.WriteString(a.Key)
+	b.WriteByte('=')
+	b.WriteString(a.Value.String())
+}
+
+func (h *emoHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *emoHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	if clone.group != "" {
+		name = clone.group + "." + name
+	}
+	clone.group = name
+	return &clone
+}
+
+// discardHandler is a slog.Handler dropping all the records.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
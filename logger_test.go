@@ -0,0 +1,122 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func newLogRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var records []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var rec map[string]any
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal("json.Decode() error", err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestWithLogger_Rejected(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	incorr := newTestIncorr(t, "https://example.com", incorruptible.WithLogger(l))
+	handler := incorr.Chk(okHandler)
+
+	buf.Reset()
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/api", http.NoBody)
+	r.AddCookie(&http.Cookie{Name: "__Host-session", Value: "garbage-garbage-garbage-garbage"})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d, want 401", w.Code)
+	}
+
+	records := newLogRecords(t, &buf)
+	if len(records) != 1 {
+		t.Fatalf("got %d log records, want 1: %v", len(records), records)
+	}
+	rec := records[0]
+	want := map[string]any{
+		"level":                  "INFO+2",
+		incorruptible.AttrEvent:  "reject",
+		incorruptible.AttrMethod: http.MethodGet,
+		incorruptible.AttrPath:   "/api",
+		incorruptible.AttrReason: string(incorruptible.ReasonInvalid),
+	}
+	for k, v := range want {
+		if rec[k] != v {
+			t.Errorf("log attribute %s=%v, want %v", k, rec[k], v)
+		}
+	}
+	if rec[incorruptible.AttrError] == nil {
+		t.Error("missing log attribute", incorruptible.AttrError)
+	}
+}
+
+func TestWithLogger_Trace(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	incorr := newTestIncorr(t, "https://example.com", incorruptible.WithLogger(l))
+
+	buf.Reset()
+	tv, err := incorruptible.NewTValues(incorruptible.String(0, "hello"))
+	if err != nil {
+		t.Fatal("NewTValues() error", err)
+	}
+	token, err := incorr.Encode(tv)
+	if err != nil {
+		t.Fatal("Encode() error", err)
+	}
+	if _, err = incorr.Decode(token); err != nil {
+		t.Fatal("Decode() error", err)
+	}
+
+	stages := map[string]bool{}
+	for _, rec := range newLogRecords(t, &buf) {
+		if s, ok := rec[incorruptible.AttrStage].(string); ok {
+			stages[s] = true
+		}
+	}
+	for _, s := range []string{
+		"Encode Marshal", "Encode result = BasE91", "Decode DecodeString BasE91",
+		"Unmarshal", "Unmarshal Expiry IP", "Unmarshal Values", "Decode result",
+	} {
+		if !stages[s] {
+			t.Errorf("missing trace stage %q within %v", s, stages)
+		}
+	}
+}
+
+func TestWithLogger_Nil(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com", incorruptible.WithLogger(nil))
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	w := httptest.NewRecorder()
+	incorr.Chk(okHandler).ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d, want 401", w.Code)
+	}
+}
@@ -86,11 +86,20 @@ func Marshal(tv TValues, magic uint8) ([]byte, error) {
 
 	if s.compressed {
 		c := s2.Encode(nil, b[HeaderSize:])
-		n := copy(b[HeaderSize:], c)
-		if n != len(c) {
-			return nil, fmt.Errorf("unexpected copied bytes got=%d want=%d", n, len(c))
+		if len(c) < s.payloadSize {
+			n := copy(b[HeaderSize:], c)
+			if n != len(c) {
+				return nil, fmt.Errorf("unexpected copied bytes got=%d want=%d", n, len(c))
+			}
+			b = b[:HeaderSize+n]
+		} else {
+			// incompressible payload (e.g. random data) => keep it uncompressed
+			m, err := NewMetadata(s.ipLength, false, s.nValues)
+			if err != nil {
+				return nil, err
+			}
+			m.PutHeader(b, magic)
 		}
-		b = b[:HeaderSize+n]
 	}
 
 	if EnablePadding {
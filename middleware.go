@@ -9,136 +9,155 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 // Set is a middleware putting a "session" cookie when the request has no valid "incorruptible" token.
-// The token is searched in the "session" cookie and in the first "Authorization" header.
-// The "session" cookie (that is added in the response) contains a minimalist "incorruptible" token.
-// Finally, Set stores the decoded token in the request context.
+// The token is searched using the configured extractors (see WithExtractors),
+// by default in the "session" cookie and in the "Authorization" header.
+// The "session" cookie (that is added in the response) contains a new "incorruptible" token
+// with a random visitor ID (see WithVisitorID) or the shared minimalist token (see WithMinimalistToken).
+// Finally, Set stores the decoded token and the mutable Session in the request context.
+// The cookie is re-issued when the handler modifies the Session (see SessionFromCtx).
 func (incorr *Incorruptible) Set(next http.Handler) http.Handler {
-	log.Securityf("Middleware Incorruptible.Set cookie %q MaxAge=%v setIP=%v",
-		incorr.cookie.Name, incorr.cookie.MaxAge, incorr.SetIP)
+	incorr.log.Security("Middleware", AttrEvent, "middleware", "name", "Set", "cookie", incorr.cookie.Name,
+		"max_age", incorr.cookie.MaxAge, "set_ip", incorr.SetIP, "extractors", incorr.sources())
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var cookie *http.Cookie
 		tv, a := incorr.DecodeToken(r)
 		if a != nil {
 			// no valid token found => set a new token
-			cookie, newDT, err := incorr.NewCookie(r)
+			var err error
+			cookie, tv, err = incorr.NewCookie(r)
+			if errors.Is(err, ErrUnknownHost) {
+				incorr.log.rejected("unknown_host", r, err)
+				incorr.writeErr(w, r, http.StatusForbidden, err.Error())
+				return
+			}
 			if err != nil {
-				log.S().Warning("Middleware IncorruptibleSet", err)
+				incorr.log.Warn("Set cannot create the cookie", AttrError, err)
 				return
 			}
-			http.SetCookie(w, cookie)
-			tv = newDT
 		}
-		next.ServeHTTP(w, tv.ToCtx(r))
+		incorr.serveSession(next, w, r, tv, cookie)
 	})
 }
 
-// Chk is a middleware accepting requests only if it has a valid Incorruptible cookie,
-// Chk does not consider the "Authorization" header (only the token within the cookie).
-// Use instead the Vet() middleware to also verify the "Authorization" header.
-// Chk finally stores the decoded token in the request context.
-// In dev. mode, Chk accepts requests without valid cookie but does not store invalid tokens.
+// Chk is a middleware accepting requests only if it has a valid Incorruptible token
+// found by one of the configured extractors (see WithExtractors).
+// Use WithExtractors(CookieExtractor{}) to only consider the token within the cookie.
+// Chk rejects the other requests with a "WWW-Authenticate" challenge (RFC 6750)
+// and a status code depending on the rejection Reason.
+// Chk finally stores the decoded token and the mutable Session in the request context.
+// The cookie is re-issued when the handler modifies the Session (see SessionFromCtx).
+// In dev. mode, Chk accepts requests without valid token but does not store invalid tokens,
+// the decoding error is stored instead (see ErrFromCtx).
 func (incorr *Incorruptible) Chk(next http.Handler) http.Handler {
-	log.Security("Middleware Incorruptible.Chk cookie only") // cookie DevMode=", incorr.IsDev)
+	incorr.log.Security("Middleware", AttrEvent, "middleware", "name", "Chk",
+		"extractors", incorr.sources(), "mode", incorr.mode.String())
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tv, err := incorr.DecodeCookieToken(r)
+		tv, a := incorr.DecodeToken(r)
 		switch {
-		case err == nil: // OK: put the token in the request context
-			r = tv.ToCtx(r)
-		// case incorr.IsDev:
-		//	printErr("Chk DevMode no cookie", err)
+		case a == nil:
+			incorr.serveSession(next, w, r, tv, nil)
+			return
+		case incorr.mode == ModeDev:
+			incorr.log.Debug("No valid token", "middleware", "Chk", "mode", "dev", AttrError, a[0])
+			r = errToCtx(r, a[0].(error))
 		default:
-			incorr.writeErr(w, r, http.StatusUnauthorized, err)
+			incorr.reject(w, r, a...)
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-func printErr(str string, err error) {
-	if doPrint {
-		log.Debugf("Incorr.%s: %v", str, err)
-	}
-}
-
-// Vet is a middleware accepting requests having a valid Incorruptible token
-// either in the cookie or in the first "Authorization" header.
-// Vet finally stores the decoded token in the request context.
-// In dev. mode, Vet accepts requests without a valid token but does not store invalid tokens.
+// Vet is a middleware verifying the Incorruptible token
+// found by one of the configured extractors (see WithExtractors).
+// Vet stores the decoded token in the request context.
+// The behavior on requests without a valid token depends on the Mode:
+// in strict mode, Vet rejects them like Chk;
+// in optional and dev. modes, Vet accepts them but does not store invalid tokens,
+// the decoding error is stored instead (see ErrFromCtx).
 func (incorr *Incorruptible) Vet(next http.Handler) http.Handler {
-	log.Security("Middleware Incorruptible.Vet cookie/bearer") //  DevMode=", incorr.IsDev)
+	incorr.log.Security("Middleware", AttrEvent, "middleware", "name", "Vet",
+		"extractors", incorr.sources(), "mode", incorr.mode.String())
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tv, err := incorr.DecodeToken(r)
+		tv, a := incorr.DecodeToken(r)
 		switch {
-		case err == nil:
+		case a == nil:
 			r = tv.ToCtx(r) // put the token in the request context
-		// case !incorr.IsDev:
+		case incorr.mode == ModeStrict:
+			incorr.reject(w, r, a...)
+			return
 		default:
-			//	incorr.writeErr(w, r, http.StatusUnauthorized, err...)
-			//	return
+			incorr.log.Debug("No valid token", "middleware", "Vet", AttrError, a[0])
+			r = errToCtx(r, a[0].(error))
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// DecodeToken iterates over the configured extractors
+// and returns the first valid token.
+// When no valid token is found, DecodeToken returns
+// an error followed by the error of each extractor
+// in key/value form (suitable for WriteErr).
+// The first error conveys the most relevant Reason (see ReasonOf).
 func (incorr *Incorruptible) DecodeToken(r *http.Request) (TValues, []any) {
-	var tv TValues
-	var err [2]error
-
-	for i := 0; i < 2; i++ {
-		var base91 string
-		if i == 0 {
-			base91, err[0] = incorr.CookieToken(r)
-		} else {
-			base91, err[1] = incorr.BearerToken(r)
-		}
-		if err[i] != nil {
-			continue
-		}
-		if incorr.equalMinimalistToken(base91) {
-			return EmptyTValues(), nil
-		}
-		if tv, err[i] = incorr.Decode(base91); err[i] != nil {
-			continue
+	errs := make([]any, 1, 1+2*len(incorr.extractors))
+	reason := ReasonMissing
+	source := ""
+
+	for _, ex := range incorr.extractors {
+		tv, err := incorr.DecodeExtractor(ex, r)
+		if err == nil {
+			incorr.tokenDecoded(ex.Source())
+			return tv, nil
 		}
-		if err[i] = tv.Valid(r); err[i] != nil {
-			continue
+		errs = append(errs, "error_"+ex.Source(), err)
+
+		// keep the first reason more relevant than a missing token
+		if reason == ReasonMissing {
+			reason = ReasonOf(err)
+			if reason != ReasonMissing {
+				source = ex.Source()
+			}
 		}
-		return tv, nil
 	}
 
-	return tv, []any{
-		fmt.Errorf("missing or invalid 'incorruptible' token in either "+
-			"the '%s' cookie or the 1st 'Authorization' header", incorr.cookie.Name),
-		"error_cookie", err[0],
-		"error_bearer", err[1],
-	}
+	incorr.tokenRejected(reason, source)
+
+	errs[0] = fmt.Errorf("%w: missing or invalid 'incorruptible' token in %v (cookie %q)",
+		reason, incorr.sources(), incorr.cookie.Name)
+	return TValues{}, errs
 }
 
-func (incorr *Incorruptible) DecodeCookieToken(r *http.Request) (TValues, error) {
-	base91, err := incorr.CookieToken(r)
+// DecodeExtractor decodes and verifies the token retrieved by the extractor.
+func (incorr *Incorruptible) DecodeExtractor(ex TokenExtractor, r *http.Request) (TValues, error) {
+	base91, err := ex.Extract(incorr, r)
 	if err != nil {
 		return TValues{}, err
 	}
-	if incorr.equalMinimalistToken(base91) {
-		return EmptyTValues(), nil
-	}
-	tv, err := incorr.Decode(base91)
+	tv, err := incorr.decodeValid(base91, r)
 	if err != nil {
-		return tv, err
+		incorr.auditRejection(r, ex.Source(), tv, err)
 	}
-	return tv, tv.Valid(r)
+	return tv, err
+}
+
+func (incorr *Incorruptible) DecodeCookieToken(r *http.Request) (TValues, error) {
+	return incorr.DecodeExtractor(CookieExtractor{}, r)
 }
 
 func (incorr *Incorruptible) DecodeBearerToken(r *http.Request) (TValues, error) {
-	base91, err := incorr.BearerToken(r)
-	if err != nil {
-		return TValues{}, err
-	}
+	return incorr.DecodeExtractor(BearerExtractor{}, r)
+}
+
+func (incorr *Incorruptible) decodeValid(base91 string, r *http.Request) (TValues, error) {
 	if incorr.equalMinimalistToken(base91) {
 		return EmptyTValues(), nil
 	}
@@ -146,14 +165,28 @@ func (incorr *Incorruptible) DecodeBearerToken(r *http.Request) (TValues, error)
 	if err != nil {
 		return tv, err
 	}
-	return tv, tv.Valid(r)
+	if err = tv.Valid(r); err != nil {
+		return tv, err
+	}
+	return tv, incorr.checkRevoked(tv)
+}
+
+// sources lists the token sources of the configured extractors.
+func (incorr *Incorruptible) sources() []string {
+	sources := make([]string, 0, len(incorr.extractors))
+	for _, ex := range incorr.extractors {
+		sources = append(sources, ex.Source())
+	}
+	return sources
 }
 
 // CookieToken returns the token (in base91 format) from the cookie.
+// The chunks of a large token are reassembled (see SetCookie).
 func (incorr *Incorruptible) CookieToken(r *http.Request) (string, error) {
-	cookie, err := r.Cookie(incorr.cookie.Name)
+	base, _ := incorr.profile(r) // read the cookie even if the host is unknown
+	cookie, err := r.Cookie(base.Name)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%w: %w", ReasonMissing, err)
 	}
 
 	// TODO: Add other verifications, but do not break specific usages.
@@ -167,39 +200,81 @@ func (incorr *Incorruptible) CookieToken(r *http.Request) (string, error) {
 	// 	return "", fmt.Errorf("want cookie Secure=%v but got %v", s.cookie.Secure, cookie.Secure)
 	// }
 
-	return trimTokenScheme(cookie.Value)
+	value := cookie.Value
+	if strings.HasPrefix(value, chunkMarker) {
+		value, err = joinChunks(r, base.Name, value)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return incorr.trimTokenScheme(value)
 }
 
 // BearerToken returns the token (in base91 format) from the HTTP Authorization header.
+// The authentication scheme is case-insensitive (RFC 7235) and surrounding whitespace is tolerated.
+// When several Authorization headers are present, BearerToken selects the one
+// using the configured authentication scheme, and rejects the request if this scheme is ambiguous.
 func (incorr *Incorruptible) BearerToken(r *http.Request) (string, error) {
-	auth := r.Header.Get("Authorization")
-	if auth == "" {
-		return "", errors.New("no 'Authorization: " + prefixScheme + "xxxxxxxx' in the request header")
+	credentials, err := incorr.credentials(r.Header.Values("Authorization"))
+	if err != nil {
+		return "", err
 	}
-
-	return trimBearerScheme(auth)
+	return incorr.trimTokenScheme(credentials)
 }
 
-func trimTokenScheme(uri string) (string, error) {
-	const schemeSize = len(tokenScheme)
-	if len(uri) < schemeSize+Base91MinSize {
-		return "", fmt.Errorf("token URI too short: %d < %d", len(uri), schemeSize+Base91MinSize)
+// credentials returns the credentials of the only Authorization header using the configured scheme.
+func (incorr *Incorruptible) credentials(headers []string) (string, error) {
+	var credentials string
+	found := 0
+
+	for _, h := range headers {
+		scheme, c := cutAuthScheme(h)
+		if !strings.EqualFold(scheme, incorr.authScheme) {
+			continue
+		}
+		credentials = c
+		found++
 	}
-	if uri[:schemeSize] != tokenScheme {
-		return "", fmt.Errorf("want token URI in format '"+tokenScheme+"xxxxxxxx' got len=%d", len(uri))
+
+	switch found {
+	case 0:
+		return "", fmt.Errorf("%w: no 'Authorization: %s %sxxxxxxxx' in the request header",
+			ReasonMissing, incorr.authScheme, incorr.tokenScheme)
+	case 1:
+	default:
+		return "", fmt.Errorf("%w: ambiguous request having %d 'Authorization: %s' headers",
+			ReasonMalformed, found, incorr.authScheme)
 	}
-	tokenBase91 := uri[schemeSize:]
-	return tokenBase91, nil
+
+	if strings.ContainsAny(credentials, " \t") {
+		return "", fmt.Errorf("%w: unexpected whitespace within the '%s' credentials", ReasonMalformed, incorr.authScheme)
+	}
+
+	return credentials, nil
 }
 
-func trimBearerScheme(auth string) (string, error) {
-	const prefixSize = len(prefixScheme)
-	if len(auth) < prefixSize+Base91MinSize {
-		return "", fmt.Errorf("bearer too short: %d < %d", len(auth), prefixSize+Base91MinSize)
+// cutAuthScheme splits the Authorization header value into the scheme and the credentials.
+// The separator is one or more spaces or horizontal tabs (RFC 7235).
+func cutAuthScheme(auth string) (scheme, credentials string) {
+	const whitespace = " \t"
+	auth = strings.Trim(auth, whitespace)
+	i := strings.IndexAny(auth, whitespace)
+	if i < 0 {
+		return auth, ""
 	}
-	if auth[:prefixSize] != prefixScheme {
-		return "", fmt.Errorf("want format '"+prefixScheme+"xxxxxxxx' got len=%d", len(auth))
+	return auth[:i], strings.TrimLeft(auth[i:], whitespace)
+}
+
+// trimTokenScheme drops the token URI scheme (case-insensitive, see RFC 3986).
+func (incorr *Incorruptible) trimTokenScheme(uri string) (string, error) {
+	schemeSize := len(incorr.tokenScheme)
+	if len(uri) < schemeSize+Base91MinSize {
+		return "", fmt.Errorf("%w: token URI too short: %d < %d", ReasonInvalid, len(uri), schemeSize+Base91MinSize)
+	}
+	if !strings.EqualFold(uri[:schemeSize], incorr.tokenScheme) {
+		return "", fmt.Errorf("%w: want token URI in format '%sxxxxxxxx' got len=%d", ReasonInvalid, incorr.tokenScheme, len(uri))
 	}
-	tokenBase91 := auth[prefixSize:]
+	tokenBase91 := uri[schemeSize:]
 	return tokenBase91, nil
 }
@@ -0,0 +1,166 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func newTestIncorr(t *testing.T, rawURL string, opts ...incorruptible.Option) *incorruptible.Incorruptible {
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal("url.Parse() error", err)
+	}
+
+	secretKey := []byte("1234567890" + "123456")
+	return incorruptible.New(nil, []*url.URL{u}, secretKey, "session", 3600, false, opts...)
+}
+
+func TestChk_Challenge(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com/", incorruptible.WithRealm("api"))
+
+	expired := incorruptible.EmptyTValues()
+	expired.SetExpiryTime(time.Now().Add(-time.Hour))
+	expiredToken, err := incorr.Encode(expired)
+	if err != nil {
+		t.Fatal("Encode() error", err)
+	}
+
+	cases := []struct {
+		name       string
+		auth       []string
+		wantStatus int
+		wantHeader string
+	}{
+		{
+			"missing", nil, http.StatusUnauthorized,
+			`Bearer realm="api"`,
+		},
+		{
+			"expired", []string{"Bearer i:" + expiredToken}, http.StatusUnauthorized,
+			`Bearer realm="api", error="invalid_token", error_description="The token expired"`,
+		},
+		{
+			"ambiguous", []string{"Bearer i:" + expiredToken, "Bearer i:" + expiredToken}, http.StatusBadRequest,
+			`Bearer realm="api", error="invalid_request", error_description="The request is malformed"`,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+			for _, a := range c.auth {
+				r.Header.Add("Authorization", a)
+			}
+			w := httptest.NewRecorder()
+
+			incorr.Chk(okHandler).ServeHTTP(w, r)
+
+			if w.Code != c.wantStatus {
+				t.Errorf("Chk() status = %d, want %d", w.Code, c.wantStatus)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); got != c.wantHeader {
+				t.Errorf("Chk() WWW-Authenticate = %q, want %q", got, c.wantHeader)
+			}
+		})
+	}
+}
+
+func TestModes(t *testing.T) {
+	t.Parallel()
+
+	optional := newTestIncorr(t, "https://example.com/")
+	strict := newTestIncorr(t, "https://example.com/", incorruptible.WithMode(incorruptible.ModeStrict))
+	dev := newTestIncorr(t, "http://localhost:8080/", incorruptible.WithMode(incorruptible.ModeDev))
+
+	cases := []struct {
+		name       string
+		incorr     *incorruptible.Incorruptible
+		middleware func(*incorruptible.Incorruptible) func(http.Handler) http.Handler
+		auth       string
+		wantStatus int
+		wantReason incorruptible.Reason
+	}{
+		{"optional Vet missing", optional, vet, "", http.StatusOK, incorruptible.ReasonMissing},
+		{"optional Vet invalid", optional, vet, "Bearer i:garbage", http.StatusOK, incorruptible.ReasonInvalid},
+		{"optional Chk missing", optional, chk, "", http.StatusUnauthorized, ""},
+		{"strict Vet missing", strict, vet, "", http.StatusUnauthorized, ""},
+		{"strict Vet invalid", strict, vet, "Bearer i:garbage", http.StatusUnauthorized, ""},
+		{"strict Chk missing", strict, chk, "", http.StatusUnauthorized, ""},
+		{"dev Vet missing", dev, vet, "", http.StatusOK, incorruptible.ReasonMissing},
+		{"dev Chk missing", dev, chk, "", http.StatusOK, incorruptible.ReasonMissing},
+		{"dev Chk invalid", dev, chk, "Bearer i:garbage", http.StatusOK, incorruptible.ReasonInvalid},
+	}
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			var gotReason incorruptible.Reason
+			var stored bool
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, stored = incorruptible.FromCtx(r)
+				if err := incorruptible.ErrFromCtx(r); err != nil {
+					gotReason = incorruptible.ReasonOf(err)
+				}
+				w.WriteHeader(http.StatusOK)
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			if c.auth != "" {
+				r.Header.Set("Authorization", c.auth)
+			}
+			w := httptest.NewRecorder()
+
+			c.middleware(c.incorr)(next).ServeHTTP(w, r)
+
+			if w.Code != c.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, c.wantStatus)
+			}
+			if gotReason != c.wantReason {
+				t.Errorf("ErrFromCtx() reason = %q, want %q", gotReason, c.wantReason)
+			}
+			if stored {
+				t.Error("FromCtx() must not store an invalid token")
+			}
+		})
+	}
+}
+
+func TestModeDev_NotLocalhost(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("New() must panic when dev. mode is requested for a non-localhost URL")
+		}
+	}()
+
+	newTestIncorr(t, "https://example.com/", incorruptible.WithMode(incorruptible.ModeDev))
+}
+
+func vet(incorr *incorruptible.Incorruptible) func(http.Handler) http.Handler { return incorr.Vet }
+func chk(incorr *incorruptible.Incorruptible) func(http.Handler) http.Handler { return incorr.Chk }
@@ -0,0 +1,78 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"net"
+	"net/url"
+)
+
+// Mode defines how the Chk and Vet middlewares handle the requests without a valid token.
+type Mode int
+
+const (
+	// ModeOptional (default): Chk rejects the request,
+	// Vet accepts the request and stores the decoding error in the request context.
+	ModeOptional Mode = iota
+	// ModeStrict: both Chk and Vet reject the request.
+	ModeStrict
+	// ModeDev: both Chk and Vet accept the request and store the decoding error in the request context.
+	// The dev. mode is accepted only when all the URLs passed to New are "http://localhost".
+	ModeDev
+)
+
+func (mode Mode) String() string {
+	switch mode {
+	case ModeOptional:
+		return "optional"
+	case ModeStrict:
+		return "strict"
+	case ModeDev:
+		return "dev"
+	default:
+		return "unknown"
+	}
+}
+
+// WithMode sets how the Chk and Vet middlewares handle the requests without a valid token.
+func WithMode(mode Mode) Option {
+	return func(incorr *Incorruptible) {
+		incorr.mode = mode
+	}
+}
+
+// checkMode panics when the dev. mode is requested on other origins than http://localhost.
+func (incorr *Incorruptible) checkMode(urls []*url.URL) {
+	switch incorr.mode {
+	case ModeOptional, ModeStrict:
+		incorr.log.Security("Mode", "mode", incorr.mode.String(), "vet_accepts_invalid", incorr.mode == ModeOptional)
+	case ModeDev:
+		if !isLocalhost(urls) {
+			log.Panic("Dev. mode is only allowed for http://localhost but got ", urls)
+		}
+		incorr.log.Warn("DEV MODE: Chk and Vet accept missing/invalid token", "urls", urls)
+		incorr.log.Warn("DEV MODE: Never use the dev. mode in production")
+	default:
+		log.Panic("Unexpected mode ", int(incorr.mode))
+	}
+}
+
+// isLocalhost returns true when all URLs are "http://localhost" (or a loopback IP).
+func isLocalhost(urls []*url.URL) bool {
+	for _, u := range urls {
+		if u.Scheme != HTTP {
+			return false
+		}
+		host := u.Hostname()
+		if host == "localhost" {
+			continue
+		}
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return false
+		}
+	}
+	return len(urls) > 0
+}
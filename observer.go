@@ -0,0 +1,165 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"expvar"
+	"time"
+)
+
+// Stage is a step of the encode/decode pipeline timed by the Observer.
+type Stage string
+
+const (
+	StageCompress     Stage = "compress"      // Marshal: serialization and s2 compression of the values
+	StageEncrypt      Stage = "encrypt"       // AES-GCM or ChaCha20-Poly1305 encryption
+	StageBase91Encode Stage = "base91_encode" // encryption output to BasE91 text
+	StageBase91Decode Stage = "base91_decode" // BasE91 text to encrypted data
+	StageDecrypt      Stage = "decrypt"       // decryption and authentication
+	StageDecompress   Stage = "decompress"    // Unmarshal: s2 decompression and parsing of the values
+)
+
+// Observer receives the events of the token lifecycle and the timings of the pipeline stages.
+// The callbacks are invoked synchronously by the request goroutines:
+// they must be fast and safe for concurrent use.
+// Embed NopObserver to implement only some callbacks.
+type Observer interface {
+	// TokenIssued is called when a new token is created
+	// (NewCookie, NewCookieFromValues, Regenerate and the Set middleware),
+	// except the shared minimalist token (see WithMinimalistToken).
+	TokenIssued()
+	// TokenDecoded is called when the extractor source ("cookie", "bearer"…) provides a valid token.
+	TokenDecoded(source string)
+	// TokenRejected is called when no extractor provides a valid token.
+	// source is the extractor responsible for the reason, empty when no token was found.
+	TokenRejected(reason Reason, source string)
+	// TokenRefreshed is called when the Session re-issues the cookie with modified values
+	// (the cookie re-issued by Regenerate is counted by TokenIssued).
+	TokenRefreshed()
+	// TokenRevoked is called when a token is revoked (see Revoke).
+	TokenRevoked()
+	// StageDone reports the duration of a successful pipeline stage.
+	StageDone(stage Stage, d time.Duration)
+}
+
+// NopObserver is an Observer ignoring all the events.
+type NopObserver struct{}
+
+func (NopObserver) TokenIssued()                   {}
+func (NopObserver) TokenDecoded(string)            {}
+func (NopObserver) TokenRejected(Reason, string)   {}
+func (NopObserver) TokenRefreshed()                {}
+func (NopObserver) TokenRevoked()                  {}
+func (NopObserver) StageDone(Stage, time.Duration) {}
+
+// WithObserver sets the Observer of the token lifecycle (default is none).
+// The pipeline stages are only timed when an Observer is set.
+func WithObserver(o Observer) Option {
+	return func(incorr *Incorruptible) {
+		incorr.observer = o
+	}
+}
+
+// startStage returns the start time of a pipeline stage, zero when no Observer is set.
+func (incorr *Incorruptible) startStage() time.Time {
+	if incorr.observer == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (incorr *Incorruptible) stageDone(stage Stage, start time.Time) {
+	if incorr.observer != nil {
+		incorr.observer.StageDone(stage, time.Since(start))
+	}
+}
+
+func (incorr *Incorruptible) tokenIssued() {
+	if incorr.observer != nil {
+		incorr.observer.TokenIssued()
+	}
+}
+
+func (incorr *Incorruptible) tokenDecoded(source string) {
+	if incorr.observer != nil {
+		incorr.observer.TokenDecoded(source)
+	}
+}
+
+func (incorr *Incorruptible) tokenRejected(reason Reason, source string) {
+	if incorr.observer != nil {
+		incorr.observer.TokenRejected(reason, source)
+	}
+}
+
+func (incorr *Incorruptible) tokenRefreshed() {
+	if incorr.observer != nil {
+		incorr.observer.TokenRefreshed()
+	}
+}
+
+func (incorr *Incorruptible) tokenRevoked() {
+	if incorr.observer != nil {
+		incorr.observer.TokenRevoked()
+	}
+}
+
+// ExpvarObserver is an Observer publishing counters through the expvar package
+// (JSON served by the "/debug/vars" endpoint):
+//
+//	{
+//	  "issued": 12, "refreshed": 3, "revoked": 1,
+//	  "decoded":         {"cookie": 40, "bearer": 2},
+//	  "rejected":        {"missing_token": 5, "expired_token": 1},
+//	  "rejected_source": {"cookie": 1},
+//	  "stage_count":     {"encrypt": 15, "decrypt": 47},
+//	  "stage_ns":        {"encrypt": 61200, "decrypt": 170400}
+//	}
+//
+// The average duration of a stage is stage_ns / stage_count.
+type ExpvarObserver struct {
+	issued         expvar.Int
+	refreshed      expvar.Int
+	revoked        expvar.Int
+	decoded        expvar.Map
+	rejected       expvar.Map
+	rejectedSource expvar.Map
+	stageCount     expvar.Map
+	stageNS        expvar.Map
+}
+
+// NewExpvarObserver publishes the counters under the expvar name.
+// As expvar.Publish, NewExpvarObserver panics when the name is already used.
+func NewExpvarObserver(name string) *ExpvarObserver {
+	o := &ExpvarObserver{}
+	m := expvar.NewMap(name)
+	m.Set("issued", &o.issued)
+	m.Set("refreshed", &o.refreshed)
+	m.Set("revoked", &o.revoked)
+	m.Set("decoded", &o.decoded)
+	m.Set("rejected", &o.rejected)
+	m.Set("rejected_source", &o.rejectedSource)
+	m.Set("stage_count", &o.stageCount)
+	m.Set("stage_ns", &o.stageNS)
+	return o
+}
+
+func (o *ExpvarObserver) TokenIssued()               { o.issued.Add(1) }
+func (o *ExpvarObserver) TokenDecoded(source string) { o.decoded.Add(source, 1) }
+func (o *ExpvarObserver) TokenRefreshed()            { o.refreshed.Add(1) }
+func (o *ExpvarObserver) TokenRevoked()              { o.revoked.Add(1) }
+
+func (o *ExpvarObserver) TokenRejected(reason Reason, source string) {
+	o.rejected.Add(string(reason), 1)
+	if source != "" {
+		o.rejectedSource.Add(source, 1)
+	}
+}
+
+func (o *ExpvarObserver) StageDone(stage Stage, d time.Duration) {
+	o.stageCount.Add(string(stage), 1)
+	o.stageNS.Add(string(stage), d.Nanoseconds())
+}
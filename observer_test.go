@@ -0,0 +1,149 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func TestExpvarObserver(t *testing.T) {
+	t.Parallel()
+
+	name := fmt.Sprint("incorr_test_observer_", time.Now().UnixNano()) // unique name with "go test -count=N"
+	o := incorruptible.NewExpvarObserver(name)
+	incorr := newTestIncorr(t, "https://example.com", incorruptible.WithObserver(o))
+	handler := incorr.Chk(okHandler)
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	cookie, _, err := incorr.NewCookie(r, incorruptible.String(0, "hello"))
+	if err != nil {
+		t.Fatal("NewCookie() error", err)
+	}
+
+	// valid cookie
+	r = httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	r.AddCookie(cookie)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	// tampered cookie
+	r = httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	r.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value[:len(cookie.Value)-2] + "AA"})
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	// no token
+	r = httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	var got struct {
+		Issued         int64            `json:"issued"`
+		Decoded        map[string]int64 `json:"decoded"`
+		Rejected       map[string]int64 `json:"rejected"`
+		RejectedSource map[string]int64 `json:"rejected_source"`
+		StageCount     map[string]int64 `json:"stage_count"`
+		StageNS        map[string]int64 `json:"stage_ns"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &got); err != nil {
+		t.Fatal("json.Unmarshal() error", err)
+	}
+
+	if got.Issued != 1 {
+		t.Errorf("issued=%d, want 1", got.Issued)
+	}
+	if got.Decoded["cookie"] != 1 {
+		t.Errorf("decoded=%v, want cookie=1", got.Decoded)
+	}
+	if got.Rejected[string(incorruptible.ReasonTampered)] != 1 || got.Rejected[string(incorruptible.ReasonMissing)] != 1 {
+		t.Errorf("rejected=%v, want tampered=1 and missing=1", got.Rejected)
+	}
+	if got.RejectedSource["cookie"] != 1 || len(got.RejectedSource) != 1 {
+		t.Errorf("rejected_source=%v, want cookie=1", got.RejectedSource)
+	}
+
+	for _, s := range []incorruptible.Stage{
+		incorruptible.StageCompress, incorruptible.StageEncrypt, incorruptible.StageBase91Encode,
+		incorruptible.StageBase91Decode, incorruptible.StageDecrypt, incorruptible.StageDecompress,
+	} {
+		if got.StageCount[string(s)] == 0 {
+			t.Errorf("stage %s not counted: %v", s, got.StageCount)
+		}
+	}
+	if got.StageCount[string(incorruptible.StageDecrypt)] != 1 {
+		t.Errorf("decrypt count=%d, want 1 (tampered token not counted)", got.StageCount[string(incorruptible.StageDecrypt)])
+	}
+}
+
+type countObserver struct {
+	incorruptible.NopObserver
+	issued, refreshed, revoked int
+}
+
+func (o *countObserver) TokenIssued()    { o.issued++ }
+func (o *countObserver) TokenRefreshed() { o.refreshed++ }
+func (o *countObserver) TokenRevoked()   { o.revoked++ }
+
+func TestObserver_Revoked(t *testing.T) {
+	t.Parallel()
+
+	o := &countObserver{}
+	incorr := newTestIncorr(t, "https://example.com",
+		incorruptible.WithTokenID(1),
+		incorruptible.WithRevocationStore(incorruptible.NewMemoryStore()),
+		incorruptible.WithObserver(o))
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	tv, err := incorr.Regenerate(httptest.NewRecorder(), r, nil)
+	if err != nil {
+		t.Fatal("Regenerate() error", err)
+	}
+	if err = incorr.Revoke(tv); err != nil {
+		t.Fatal("Revoke() error", err)
+	}
+	if o.revoked != 1 {
+		t.Errorf("revoked=%d, want 1", o.revoked)
+	}
+}
+
+func TestObserver_IssuedOnce(t *testing.T) {
+	t.Parallel()
+
+	o := &countObserver{}
+	incorr := newTestIncorr(t, "https://example.com", incorruptible.WithObserver(o))
+
+	// Regenerate within the Set middleware: the Session re-issues the cookie
+	handler := incorr.Set(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := incorr.Regenerate(w, r, nil); err != nil {
+			t.Error("Regenerate() error", err)
+		}
+	}))
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	// one token issued by Set, one by Regenerate
+	if o.issued != 2 || o.refreshed != 0 {
+		t.Errorf("issued=%d refreshed=%d, want 2 and 0", o.issued, o.refreshed)
+	}
+
+	// the shared minimalist token is not counted
+	m := &countObserver{}
+	minimalist := incorruptible.New(nil, []*url.URL{{Scheme: "https", Host: "example.com"}},
+		[]byte("1234567890123456"), "session", 0, false,
+		incorruptible.WithMinimalistToken(), incorruptible.WithObserver(m))
+	if _, _, err := minimalist.NewCookie(r); err != nil {
+		t.Fatal("NewCookie() error", err)
+	}
+	if m.issued != 0 {
+		t.Errorf("issued=%d, want 0 for the minimalist token", m.issued)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import "strings"
+
+// Option customizes an Incorruptible created by New or by Profile.
+// The options are applied in order, after the default settings.
+type Option func(*Incorruptible)
+
+func (incorr *Incorruptible) apply(opts []Option) {
+	for _, opt := range opts {
+		if opt != nil {
+			opt(incorr)
+		}
+	}
+}
+
+// WithAuthScheme sets the authentication scheme of the Authorization header.
+// Default is "Bearer". The scheme is matched case-insensitively.
+func WithAuthScheme(scheme string) Option {
+	return func(incorr *Incorruptible) {
+		if scheme == "" || strings.ContainsAny(scheme, " \t,") {
+			log.Panicf("WithAuthScheme(%q) requires a non-empty scheme without whitespace nor comma", scheme)
+		}
+		incorr.authScheme = scheme
+	}
+}
+
+// WithTokenScheme sets the URI scheme prefixing the token in the cookie
+// and in the headers. Default is "i:". An empty prefix is accepted
+// for HTTP clients sending the raw token: "Authorization: Bearer xxxxxxxx".
+func WithTokenScheme(prefix string) Option {
+	return func(incorr *Incorruptible) {
+		if strings.ContainsAny(prefix, " \t,;\"\\") {
+			log.Panicf("WithTokenScheme(%q) contains a character not welcome in cookie or header", prefix)
+		}
+		incorr.tokenScheme = prefix
+	}
+}
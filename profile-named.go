@@ -0,0 +1,153 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// WithCookieName sets the cookie name, overriding the name passed to New or to Profile.
+func WithCookieName(name string) Option {
+	return func(incorr *Incorruptible) {
+		incorr.cookie.Name = name
+	}
+}
+
+// WithMaxAge sets the cookie Max-Age and the token expiry (in seconds),
+// overriding the maxAge passed to New (Profile inherits it by default).
+func WithMaxAge(maxAge int) Option {
+	return func(incorr *Incorruptible) {
+		incorr.cookie.MaxAge = maxAge
+	}
+}
+
+// WithSetIP binds the token to the remote IP, overriding the setIP passed to New
+// (Profile inherits it by default).
+func WithSetIP(setIP bool) Option {
+	return func(incorr *Incorruptible) {
+		incorr.SetIP = setIP
+	}
+}
+
+// Profile returns a handle managing another type of token (preferences, remember-me…)
+// with its own cookie (named after the profile by default), Max-Age, IP binding
+// and cookie attributes, customized by the options. The handle provides the same API
+// (NewCookie, DecodeCookieToken, DeadCookieFor, Set, Chk, Vet…).
+//
+// The profiles share the secret key, the cipher and the encoding alphabet
+// with their parent, as well as the other settings (extractors, mode, logger…).
+// The value keys are specific to each type of token: the options using a key
+// (WithCSRF, WithAuthTimeKey, WithTokenID, WithCarryOver, WithVisitorID…)
+// and WithMinimalistToken are not inherited.
+//
+// The tokens are bound to their profile name: the tokens of one profile
+// are rejected by the others (ReasonWrongProfile), even through the Authorization header.
+// The flash cookies, the return URLs and the signed URLs are also bound to their profile.
+// The profile names and the cookie names (including the flash cookies and the chunks)
+// must be unique among the parent and its profiles.
+// Profile must be called during the initialization.
+//
+// The profiles share the request context (see FromCtx and SessionFromCtx):
+// when the middlewares of several profiles are chained on the same route,
+// the handler gets the values of the innermost middleware.
+//
+// Example:
+//
+//	prefs := incorr.Profile("prefs", incorruptible.WithMaxAge(365*24*3600), incorruptible.WithSetIP(false))
+//	router.Handle("/settings", prefs.Set(settingsHandler))
+func (incorr *Incorruptible) Profile(name string, opts ...Option) *Incorruptible {
+	if name == "" || strings.ContainsAny(name, " \t,;=\"\\\x00") {
+		log.Panicf("Profile(%q) requires a name usable as cookie name", name)
+	}
+	if _, ok := incorr.names[name]; ok {
+		log.Panicf("Profile(%q) already exists", name)
+	}
+	incorr.names[name] = struct{}{}
+
+	p := *incorr // shallow copy sharing the cipher, the alphabet and the settings
+	p.ad = []byte("profile:" + name)
+	p.cookie = http.Cookie{Name: name, MaxAge: incorr.cookie.MaxAge}
+	p.profiles = nil
+
+	// the value keys are specific to each type of token
+	p.csrfKey, p.authTimeKey, p.tokenIDKey, p.visitorKey = -1, -1, -1, -1
+	p.carryOver, p.revocations, p.migrate, p.minimalist = nil, nil, nil, false
+
+	// the options appending to these slices must not modify the parent ones
+	p.extractors = slices.Clip(p.extractors)
+	p.origins = slices.Clip(p.origins)
+	p.csrfExempt = slices.Clip(p.csrfExempt)
+	p.navRoutes = slices.Clip(p.navRoutes)
+	p.corsRoutes = slices.Clip(p.corsRoutes)
+
+	p.apply(opts)
+	p.setup() // panics when a cookie name is already used by the parent or a sibling
+	return &p
+}
+
+// reserveCookieNames registers the names of the cookies (main, flash and chunks)
+// in the set shared by the profiles. reserveCookieNames panics
+// when one of these names is already used by another profile.
+func (incorr *Incorruptible) reserveCookieNames() {
+	own := map[string]struct{}{}
+	for _, p := range incorr.profiles {
+		own[p.cookie.Name] = struct{}{}
+		own[p.cookie.Name+flashSuffix] = struct{}{}
+		for i := 0; i < MaxChunks; i++ {
+			own[chunkName(p.cookie.Name, i)] = struct{}{}
+		}
+	}
+	if incorr.csrfKey >= 0 && incorr.csrfCookie != "" {
+		own[incorr.csrfCookie] = struct{}{}
+	}
+
+	for n := range own {
+		if _, ok := incorr.cookies[n]; ok {
+			log.Panicf("Cookie name %q is already used by another profile (see Profile)", n)
+		}
+	}
+	for n := range own {
+		incorr.cookies[n] = struct{}{}
+	}
+}
+
+// otherProfile returns the name of the other profile ("" for the parent)
+// able to decrypt the token.
+func (incorr *Incorruptible) otherProfile(base91 string) (string, bool) {
+	encrypted, err := incorr.baseN.DecodeString(base91)
+	if err != nil || len(encrypted) < encryptedMinSize {
+		return "", false
+	}
+
+	// DecryptAD decrypts in place (and zeroes the buffer on failure)
+	decrypts := func(ad []byte) bool {
+		_, err := DecryptAD(incorr.cipher, slices.Clone(encrypted), ad)
+		return err == nil
+	}
+
+	if len(incorr.ad) > 0 && decrypts(nil) {
+		return "", true // parent
+	}
+	for name := range incorr.names {
+		ad := []byte("profile:" + name)
+		if !slices.Equal(ad, incorr.ad) && decrypts(ad) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// scopedAD prefixes the additional data with the AD of the profile (see Profile):
+// the flash cookies, the return URLs and the signed URLs are also bound to their profile.
+// The additional data of the parent is unchanged.
+func (incorr *Incorruptible) scopedAD(ad []byte) []byte {
+	if len(incorr.ad) == 0 {
+		return ad
+	}
+	return slices.Concat(incorr.ad, []byte{0}, ad) // NUL cannot appear in a profile name
+}
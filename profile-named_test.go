@@ -0,0 +1,173 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func TestProfile(t *testing.T) {
+	t.Parallel()
+
+	sink := &memorySink{}
+	incorr := newTestIncorr(t, "https://example.com", incorruptible.WithCookiePrefix(incorruptible.PrefixNone),
+		incorruptible.WithAuditSink(sink))
+	prefs := incorr.Profile("prefs", incorruptible.WithMaxAge(365*24*3600), incorruptible.WithSameSite(http.SameSiteLaxMode))
+	remember := incorr.Profile("remember", incorruptible.WithCookieName("rm"), incorruptible.WithSetIP(true))
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	session, _, err := incorr.NewCookie(r, incorruptible.String(0, "session"))
+	if err != nil {
+		t.Fatal("NewCookie() error", err)
+	}
+	pref, _, err := prefs.NewCookie(r, incorruptible.String(0, "dark"))
+	if err != nil {
+		t.Fatal("prefs.NewCookie() error", err)
+	}
+	rm, _, err := remember.NewCookie(r)
+	if err != nil {
+		t.Fatal("remember.NewCookie() error", err)
+	}
+
+	if pref.Name != "prefs" || pref.MaxAge != 365*24*3600 || pref.SameSite != http.SameSiteLaxMode {
+		t.Errorf("unexpected prefs cookie %v", pref)
+	}
+	if rm.Name != "rm" || rm.MaxAge != session.MaxAge || rm.SameSite != session.SameSite {
+		t.Errorf("unexpected remember cookie %v", rm)
+	}
+	if d := prefs.DeadCookieFor(r); d.Name != "prefs" || d.MaxAge >= 0 {
+		t.Errorf("unexpected prefs dead cookie %v", d)
+	}
+
+	// each profile decodes its own cookie
+	r = httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	r.AddCookie(pref)
+	tv, err := prefs.DecodeCookieToken(r)
+	if err != nil {
+		t.Fatal("prefs.DecodeCookieToken() error", err)
+	}
+	if v, _ := tv.String(0); v != "dark" {
+		t.Errorf("prefs value %q, want dark", v)
+	}
+
+	// a token of one profile is rejected by the others (not as a tampered token)
+	cases := []struct {
+		name   string
+		incorr *incorruptible.Incorruptible
+		cookie *http.Cookie
+	}{
+		{"session as prefs", prefs, &http.Cookie{Name: pref.Name, Value: session.Value}},
+		{"prefs as session", incorr, &http.Cookie{Name: session.Name, Value: pref.Value}},
+		{"prefs as remember", remember, &http.Cookie{Name: rm.Name, Value: pref.Value}},
+	}
+	for _, c := range cases {
+		r = httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+		r.AddCookie(c.cookie)
+		if _, err = c.incorr.DecodeCookieToken(r); !errors.Is(err, incorruptible.ReasonWrongProfile) {
+			t.Errorf("%s: DecodeCookieToken() error %v, want ReasonWrongProfile", c.name, err)
+		}
+	}
+
+	// an altered token is still tampered
+	r = httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	r.AddCookie(&http.Cookie{Name: pref.Name, Value: pref.Value[:len(pref.Value)-2] + "AA"})
+	if _, err = prefs.DecodeCookieToken(r); !errors.Is(err, incorruptible.ReasonTampered) {
+		t.Errorf("DecodeCookieToken() error %v, want ReasonTampered", err)
+	}
+	sink.events = nil
+
+	// the same through the Authorization header
+	r = httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	r.Header.Set("Authorization", "Bearer "+pref.Value)
+	w := httptest.NewRecorder()
+	incorr.Chk(okHandler).ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Chk() accepted a prefs token: status %d", w.Code)
+	}
+	if got := sink.kinds(); len(got) != 0 {
+		t.Errorf("the token of another profile must not be audited, got %v", got)
+	}
+	w = httptest.NewRecorder()
+	prefs.Chk(okHandler).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("prefs.Chk() status %d, want 200", w.Code)
+	}
+}
+
+func TestProfile_Panics(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com", incorruptible.WithCookiePrefix(incorruptible.PrefixNone))
+	incorr.Profile("prefs")
+	incorr.Profile("remember", incorruptible.WithCookieName("rm"))
+
+	for name, f := range map[string]func(){
+		"duplicate":      func() { incorr.Profile("prefs") },
+		"empty":          func() { incorr.Profile("") },
+		"same cookie":    func() { incorr.Profile("other", incorruptible.WithCookieName("session")) },
+		"sibling cookie": func() { incorr.Profile("other2", incorruptible.WithCookieName("rm")) },
+		"flash cookie":   func() { incorr.Profile("session-flash") },
+		"chunk cookie":   func() { incorr.Profile("prefs.0") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: Profile() must panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestProfile_ScopedAD(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com")
+	prefs := incorr.Profile("prefs")
+
+	// flash cookie of the parent presented to the profile
+	r := httptest.NewRequest(http.MethodPost, "https://example.com/", http.NoBody)
+	w := httptest.NewRecorder()
+	if err := incorr.AddFlash(w, r, "info", "hello"); err != nil {
+		t.Fatal("AddFlash() error", err)
+	}
+	flash := w.Result().Cookies()[0]
+	r = httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	r.AddCookie(&http.Cookie{Name: "__Host-prefs-flash", Value: flash.Value})
+	if got := prefs.Flashes(httptest.NewRecorder(), r); len(got) != 0 {
+		t.Errorf("prefs.Flashes() = %v, want none", got)
+	}
+
+	// return URL of the parent decoded by the profile
+	token, err := incorr.EncodeReturnTo("/orders")
+	if err != nil {
+		t.Fatal("EncodeReturnTo() error", err)
+	}
+	if _, err = prefs.DecodeReturnTo(token); !errors.Is(err, incorruptible.ErrReturnTo) {
+		t.Errorf("prefs.DecodeReturnTo() error %v, want ErrReturnTo", err)
+	}
+
+	// signed URL of the profile verified by the parent
+	signed, err := prefs.SignURL(&url.URL{Scheme: "https", Host: "example.com", Path: "/file"}, http.MethodGet, time.Minute, nil)
+	if err != nil {
+		t.Fatal("SignURL() error", err)
+	}
+	r = httptest.NewRequest(http.MethodGet, signed.String(), http.NoBody)
+	if _, err = prefs.DecodeURL(r); err != nil {
+		t.Error("prefs.DecodeURL() error", err)
+	}
+	if _, err = incorr.DecodeURL(r); !errors.Is(err, incorruptible.ReasonTampered) {
+		t.Errorf("DecodeURL() error %v, want ReasonTampered", err)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HostFallback is the policy applied when the request host and scheme
+// match none of the URLs passed to New.
+type HostFallback int
+
+const (
+	// FallbackFirst (default) uses the cookie profile of the first URL.
+	FallbackFirst HostFallback = iota
+	// FallbackReject refuses to issue a cookie: NewCookie returns ErrUnknownHost
+	// and the Set middleware responds "403 Forbidden".
+	FallbackReject
+)
+
+// ErrUnknownHost is returned when the request host matches none of the URLs
+// and the FallbackReject policy is enabled.
+var ErrUnknownHost = errors.New("request host matches none of the configured URLs")
+
+// cookieProfile is the cookie derived from one of the URLs passed to New.
+type cookieProfile struct {
+	scheme string
+	host   string
+	cookie http.Cookie
+}
+
+// WithHostFallback sets the policy for the requests from an unknown host (default is FallbackFirst).
+func WithHostFallback(policy HostFallback) Option {
+	return func(incorr *Incorruptible) {
+		if policy != FallbackFirst && policy != FallbackReject {
+			log.Panicf("WithHostFallback(%d) unexpected value", policy)
+		}
+		incorr.fallback = policy
+	}
+}
+
+// WithTrustedProxy trusts the "X-Forwarded-Proto" header to select the cookie profile
+// (and the origin of the return URL, see WithLoginRedirect).
+// Use this option only behind a TLS-terminating reverse proxy
+// overwriting the "X-Forwarded-Proto" header sent by the client.
+// Without this option, the scheme is "https" only when the request is received over TLS:
+// behind a TLS-terminating proxy, the requests match none of the https URLs
+// and get the cookie of the first URL (or are rejected, see FallbackReject).
+func WithTrustedProxy() Option {
+	return func(incorr *Incorruptible) {
+		incorr.trustProxy = true
+	}
+}
+
+// newProfiles derives a cookie profile (Secure, Domain, Path, name prefix) for every URL.
+// The cookie attributes must be valid for the first URL (main URL).
+// For the other URLs, the incompatible attributes are relaxed
+// (e.g. SameSite=None on a http://localhost URL).
+// The duplicated scheme+host are skipped: the first URL wins.
+func newProfiles(l *logger, name string, maxAge int, urls []*url.URL, attrs cookieAttrs) ([]cookieProfile, error) {
+	profiles := make([]cookieProfile, 0, len(urls))
+
+	for i, u := range urls {
+		secure, dns, dir := extractMainDomain(u)
+		host := strings.ToLower(dns)
+
+		if findProfile(profiles, u.Scheme, host) != nil {
+			continue
+		}
+
+		cookie, err := newCookie(name, secure, dns, dir, maxAge, attrs)
+		if err != nil && i > 0 {
+			l.Warn("Relax the cookie attributes", "url", u.String(), AttrError, err)
+			cookie, err = newCookie(name, secure, dns, dir, maxAge, attrs.relax(name, secure, dns, dir))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("URL %s: %w", u, err)
+		}
+
+		profiles = append(profiles, cookieProfile{scheme: u.Scheme, host: host, cookie: cookie})
+	}
+
+	return profiles, nil
+}
+
+// relax removes the attributes incompatible with the URL.
+// The prefix policy is kept when the URL satisfies it,
+// else PrefixAuto selects the prefix allowed by the URL.
+func (a cookieAttrs) relax(name string, secure bool, dns, dir string) cookieAttrs {
+	if a.domain != "" && !domainMatch(dns, a.domain) {
+		a.domain = ""
+	}
+	if _, err := a.prefix.resolve(name, secure, dir, a.domain); err != nil {
+		a.prefix = PrefixAuto
+	}
+	if !secure {
+		a.partitioned = false
+		if a.sameSite == http.SameSiteNoneMode {
+			a.sameSite = http.SameSiteLaxMode
+		}
+	}
+	return a
+}
+
+func findProfile(profiles []cookieProfile, scheme, host string) *cookieProfile {
+	for i := range profiles {
+		if profiles[i].scheme == scheme && profiles[i].host == host {
+			return &profiles[i]
+		}
+	}
+	return nil
+}
+
+// profile returns the default cookie matching the request host and scheme.
+// The request may be nil: profile returns the cookie of the first URL.
+// The returned cookie must not be modified.
+func (incorr *Incorruptible) profile(r *http.Request) (*http.Cookie, error) {
+	if r == nil {
+		return &incorr.cookie, nil
+	}
+
+	host := strings.ToLower((&url.URL{Host: r.Host}).Hostname())
+	if p := findProfile(incorr.profiles, incorr.requestScheme(r), host); p != nil {
+		return &p.cookie, nil
+	}
+
+	if incorr.fallback == FallbackReject {
+		return &incorr.cookie, fmt.Errorf("%w: %s", ErrUnknownHost, host)
+	}
+	return &incorr.cookie, nil
+}
+
+// requestScheme returns "https" when the request is received over TLS
+// or forwarded by a trusted TLS-terminating proxy (see WithTrustedProxy).
+func (incorr *Incorruptible) requestScheme(r *http.Request) string {
+	if r.TLS != nil || r.URL.Scheme == HTTPS {
+		return HTTPS
+	}
+	if incorr.trustProxy && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), HTTPS) {
+		return HTTPS
+	}
+	return HTTP
+}
+
+// warnUntrustedProxy logs once, at construction, that the https profiles
+// match only the requests received over TLS (see WithTrustedProxy).
+// The named profiles share the URLs of their parent that has already logged.
+func (incorr *Incorruptible) warnUntrustedProxy() {
+	if incorr.trustProxy || len(incorr.ad) > 0 || (len(incorr.profiles) < 2 && incorr.fallback == FallbackFirst) {
+		return
+	}
+	for _, p := range incorr.profiles {
+		if p.scheme == HTTPS {
+			incorr.log.Info("The https cookie profiles match only the requests received over TLS, " +
+				"use WithTrustedProxy behind a TLS-terminating proxy")
+			return
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func newMultiOrigin(t *testing.T, opts ...incorruptible.Option) *incorruptible.Incorruptible {
+	t.Helper()
+
+	urls := make([]*url.URL, 0, 2)
+	for _, raw := range []string{"https://example.com/", "http://localhost:8080/"} {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal("url.Parse() error", err)
+		}
+		urls = append(urls, u)
+	}
+
+	secretKey := []byte("1234567890" + "123456")
+	return incorruptible.New(nil, urls, secretKey, "session", 3600, false, opts...)
+}
+
+func TestCookieProfiles(t *testing.T) {
+	t.Parallel()
+
+	// SameSite=None is relaxed for http://localhost
+	incorr := newMultiOrigin(t, incorruptible.WithSameSite(http.SameSiteNoneMode))
+
+	cases := []struct {
+		name       string
+		target     string
+		wantName   string
+		wantSecure bool
+		wantSite   http.SameSite
+	}{
+		{"main", "https://example.com/", "__Host-session", true, http.SameSiteNoneMode},
+		{"localhost", "http://localhost:8080/", "session", false, http.SameSiteLaxMode},
+		{"unknown host", "http://other.org/", "__Host-session", true, http.SameSiteNoneMode},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, c.target, http.NoBody)
+			cookie, _, err := incorr.NewCookie(r)
+			if err != nil {
+				t.Fatal("NewCookie() error", err)
+			}
+			if cookie.Name != c.wantName || cookie.Secure != c.wantSecure || cookie.SameSite != c.wantSite {
+				t.Errorf("NewCookie() got %s Secure=%v SameSite=%v want %s Secure=%v SameSite=%v",
+					cookie.Name, cookie.Secure, cookie.SameSite, c.wantName, c.wantSecure, c.wantSite)
+			}
+			if dead := incorr.DeadCookieFor(r); dead.Name != c.wantName {
+				t.Errorf("DeadCookieFor() got %s want %s", dead.Name, c.wantName)
+			}
+
+			// the token is read from the cookie of the selected profile
+			r.AddCookie(cookie)
+			if _, err := incorr.DecodeCookieToken(r); err != nil {
+				t.Error("DecodeCookieToken() error", err)
+			}
+		})
+	}
+}
+
+func TestCookieProfiles_FallbackReject(t *testing.T) {
+	t.Parallel()
+
+	incorr := newMultiOrigin(t, incorruptible.WithHostFallback(incorruptible.FallbackReject))
+
+	r := httptest.NewRequest(http.MethodGet, "http://other.org/", http.NoBody)
+	_, _, err := incorr.NewCookie(r)
+	if !errors.Is(err, incorruptible.ErrUnknownHost) {
+		t.Errorf("NewCookie() error = %v, want ErrUnknownHost", err)
+	}
+
+	w := httptest.NewRecorder()
+	incorr.Set(okHandler).ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Set() status = %d, want 403", w.Code)
+	}
+	if len(w.Result().Cookies()) > 0 {
+		t.Error("Set() must not issue a cookie for an unknown host")
+	}
+}
+
+func TestCookieProfiles_TrustedProxy(t *testing.T) {
+	t.Parallel()
+
+	direct := newMultiOrigin(t, incorruptible.WithHostFallback(incorruptible.FallbackReject))
+	proxied := newMultiOrigin(t, incorruptible.WithHostFallback(incorruptible.FallbackReject), incorruptible.WithTrustedProxy())
+
+	// plain HTTP request forwarded by a TLS-terminating proxy
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", http.NoBody)
+	r.Header.Set("X-Forwarded-Proto", "https")
+
+	// the header sent by the client is ignored by default
+	if _, _, err := direct.NewCookie(r); !errors.Is(err, incorruptible.ErrUnknownHost) {
+		t.Errorf("NewCookie() error = %v, want ErrUnknownHost", err)
+	}
+
+	cookie, _, err := proxied.NewCookie(r)
+	if err != nil {
+		t.Fatal("NewCookie() error", err)
+	}
+	if cookie.Name != "__Host-session" || !cookie.Secure {
+		t.Errorf("NewCookie() got %s Secure=%v want __Host-session Secure=true", cookie.Name, cookie.Secure)
+	}
+}
+
+func TestCookieProfiles_KeepPrefixPolicy(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		prefix    incorruptible.CookiePrefix
+		wantMain  string
+		wantLocal string
+	}{
+		{"none", incorruptible.PrefixNone, "session", "session"},
+		{"host", incorruptible.PrefixHost, "__Host-session", "session"},
+		{"secure", incorruptible.PrefixSecure, "__Secure-session", "session"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			incorr := newMultiOrigin(t, incorruptible.WithCookiePrefix(c.prefix))
+
+			for target, want := range map[string]string{
+				"https://example.com/":   c.wantMain,
+				"http://localhost:8080/": c.wantLocal,
+			} {
+				r := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+				if got := incorr.DeadCookieFor(r).Name; got != want {
+					t.Errorf("%s: cookie name got %s want %s", target, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate is the configuration of a token bucket:
+// the bucket holds up to Burst tokens and gains one token every Interval.
+// Each request takes one token.
+type Rate struct {
+	Burst    int
+	Interval time.Duration
+}
+
+// NewRate returns a Rate allowing n requests per period (with bursts up to n).
+func NewRate(n int, period time.Duration) Rate {
+	if n <= 0 || period <= 0 {
+		log.Panicf("NewRate(%d, %v) requires positive values", n, period)
+	}
+	return Rate{Burst: n, Interval: period / time.Duration(n)}
+}
+
+// RateStore keeps the state of the token buckets.
+// Implement RateStore to share the state between several instances (e.g. Redis).
+type RateStore interface {
+	// Take removes a token from the bucket identified by key.
+	// When the bucket is empty, Take returns false and the duration before the next token.
+	Take(key string, rate Rate) (ok bool, retryAfter time.Duration, err error)
+}
+
+// LimitKeyFunc derives the rate-limiter key from the request and its token values.
+// LimitKeyFunc returns false when the key cannot be derived.
+type LimitKeyFunc func(r *http.Request, tv TValues) (string, bool)
+
+// LimitByValue uses the value stored at the given key (e.g. user ID).
+func LimitByValue(key int) LimitKeyFunc {
+	return func(_ *http.Request, tv TValues) (string, bool) {
+		if key < 0 || key >= len(tv.Values) || len(tv.Values[key]) == 0 {
+			return "", false
+		}
+		return "v" + strconv.Itoa(key) + ":" + hex.EncodeToString(tv.Values[key]), true
+	}
+}
+
+// LimitByIP uses the IP stored in the token (see SetIP), else the remote IP of the request.
+func LimitByIP() LimitKeyFunc {
+	return func(r *http.Request, tv TValues) (string, bool) {
+		if !tv.NoIP() {
+			return "ip:" + tv.IP.String(), true
+		}
+		return remoteIPKey(r)
+	}
+}
+
+// LimitByToken uses a hash of the token values (expiry, IP and values):
+// each token has its own bucket.
+func LimitByToken() LimitKeyFunc {
+	return func(_ *http.Request, tv TValues) (string, bool) {
+		if tv.Expires == 0 && tv.NoIP() && len(tv.Values) == 0 {
+			return "", false
+		}
+		h := sha256.New()
+		_ = binary.Write(h, binary.LittleEndian, tv.Expires)
+		h.Write(tv.IP)
+		for _, v := range tv.Values {
+			_ = binary.Write(h, binary.LittleEndian, uint16(len(v)))
+			h.Write(v)
+		}
+		return "t:" + hex.EncodeToString(h.Sum(nil)[:16]), true
+	}
+}
+
+func remoteIPKey(r *http.Request) (string, bool) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || ip == "" {
+		return "", false
+	}
+	return "ip:" + ip, true
+}
+
+// RateLimit is a middleware limiting the request rate per key.
+// RateLimit must be placed after the Set, Chk or Vet middleware to access the token values.
+// When the key cannot be derived (e.g. no token), the remote IP is used.
+// The throttled requests receive "429 Too Many Requests" through WriteErr
+// with the "Retry-After" header. The store may be nil to use an in-memory store.
+// When the store fails, the request is accepted (fail-open) and the error is logged.
+// The keys are prefixed by the name of the RateLimit middleware ("api/…"):
+// the middlewares sharing a store with different names do not share their buckets,
+// the instances sharing a store use the same name for the same limit.
+//
+// Example:
+//
+//	limit := incorr.RateLimit("api", incorruptible.NewRate(100, time.Minute), incorruptible.LimitByValue(keyUserID), nil)
+//	router.Handle("/api/", incorr.Chk(limit(api)))
+func (incorr *Incorruptible) RateLimit(name string, rate Rate, key LimitKeyFunc, store RateStore) func(http.Handler) http.Handler {
+	if name == "" || strings.Contains(name, "/") {
+		log.Panicf("RateLimit(%q) requires a non-empty name without slash", name)
+	}
+	if rate.Burst <= 0 || rate.Interval <= 0 {
+		log.Panicf("RateLimit requires a positive rate, got %+v", rate)
+	}
+	if key == nil {
+		key = LimitByIP()
+	}
+	if store == nil {
+		store = NewMemoryRateStore()
+	}
+
+	namespace := name + "/"
+
+	incorr.log.Security("Middleware", AttrEvent, "middleware", "name", "RateLimit",
+		"namespace", namespace, "burst", rate.Burst, "interval", rate.Interval)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tv, _ := currentValues(r)
+			k, ok := key(r, tv)
+			if !ok {
+				k, ok = remoteIPKey(r)
+			}
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			allowed, retryAfter, err := store.Take(namespace+k, rate)
+			if err != nil {
+				incorr.log.Warn("RateLimit store", AttrError, err)
+				allowed = true
+			}
+			if !allowed {
+				sec := strconv.Itoa(max(int(math.Ceil(retryAfter.Seconds())), 1))
+				w.Header().Set("Retry-After", sec)
+				incorr.writeErr(w, r, http.StatusTooManyRequests, "Too many requests, retry in "+sec+"s")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MemoryRateStore is a RateStore keeping the token buckets in memory.
+type MemoryRateStore struct {
+	mu        sync.Mutex
+	buckets   map[string]bucket
+	lastPurge time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // when the bucket is full again (depends on the rate of the bucket)
+}
+
+// NewMemoryRateStore creates an empty in-memory RateStore.
+func NewMemoryRateStore() *MemoryRateStore {
+	return &MemoryRateStore{
+		buckets:   make(map[string]bucket),
+		lastPurge: time.Now(),
+	}
+}
+
+func (ms *MemoryRateStore) Take(key string, rate Rate) (bool, time.Duration, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(ms.lastPurge) > purgePeriod {
+		ms.purge(now)
+	}
+
+	b, ok := ms.buckets[key]
+	if ok {
+		b.tokens = refill(b, now, rate)
+	} else {
+		b.tokens = float64(rate.Burst)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		b.full = fullTime(b, rate)
+		ms.buckets[key] = b
+		missing := 1 - b.tokens
+		return false, time.Duration(missing * float64(rate.Interval)), nil
+	}
+
+	b.tokens--
+	b.full = fullTime(b, rate)
+	ms.buckets[key] = b
+	return true, 0, nil
+}
+
+// purge removes the full buckets: they are equivalent to missing buckets.
+func (ms *MemoryRateStore) purge(now time.Time) {
+	ms.lastPurge = now
+	for k, b := range ms.buckets {
+		if !now.Before(b.full) {
+			delete(ms.buckets, k)
+		}
+	}
+}
+
+// fullTime returns when the bucket is full again.
+func fullTime(b bucket, rate Rate) time.Time {
+	missing := float64(rate.Burst) - b.tokens
+	return b.last.Add(time.Duration(missing * float64(rate.Interval)))
+}
+
+func refill(b bucket, now time.Time, rate Rate) float64 {
+	tokens := b.tokens + float64(now.Sub(b.last))/float64(rate.Interval)
+	return math.Min(tokens, float64(rate.Burst))
+}
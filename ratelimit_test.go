@@ -0,0 +1,132 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+
+	const keyUser = 0
+
+	incorr := newTestIncorr(t, "https://example.com/")
+	limit := incorr.RateLimit("api", incorruptible.NewRate(2, time.Hour), incorruptible.LimitByValue(keyUser), nil)
+	handler := incorr.Vet(limit(okHandler))
+
+	cookieOf := func(user string) *http.Cookie {
+		tv, err := incorruptible.NewTValues(incorruptible.String(keyUser, user))
+		if err != nil {
+			t.Fatal("NewTValues() error", err)
+		}
+		tv.SetExpiry(3600)
+		cookie, err := incorr.NewCookieFromValues(tv)
+		if err != nil {
+			t.Fatal("NewCookieFromValues() error", err)
+		}
+		return cookie
+	}
+	alice, bob := cookieOf("alice"), cookieOf("bob")
+
+	cases := []struct {
+		name       string
+		cookie     *http.Cookie
+		remoteAddr string
+		wantStatus int
+	}{
+		{"alice #1", alice, "192.0.2.1:1234", http.StatusOK},
+		{"alice #2 from another IP", alice, "192.0.2.2:1234", http.StatusOK},
+		{"alice #3", alice, "192.0.2.1:1234", http.StatusTooManyRequests},
+		{"bob #1", bob, "192.0.2.1:1234", http.StatusOK},
+		{"anonymous #1", nil, "192.0.2.9:1234", http.StatusOK},
+		{"anonymous #2", nil, "192.0.2.9:1234", http.StatusOK},
+		{"anonymous #3", nil, "192.0.2.9:1234", http.StatusTooManyRequests},
+	}
+
+	// sequential: the order matters
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/api", http.NoBody)
+		r.RemoteAddr = c.remoteAddr
+		if c.cookie != nil {
+			r.AddCookie(c.cookie)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != c.wantStatus {
+			t.Errorf("%s: status = %d, want %d", c.name, w.Code, c.wantStatus)
+		}
+		if c.wantStatus == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1800" {
+			t.Errorf("%s: Retry-After = %q, want 1800", c.name, w.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestMemoryRateStore_Refill(t *testing.T) {
+	t.Parallel()
+
+	store := incorruptible.NewMemoryRateStore()
+	rate := incorruptible.Rate{Burst: 1, Interval: 20 * time.Millisecond}
+
+	if ok, _, _ := store.Take("k", rate); !ok {
+		t.Fatal("first Take() must succeed")
+	}
+	ok, retry, _ := store.Take("k", rate)
+	if ok || retry <= 0 || retry > rate.Interval {
+		t.Fatalf("second Take() = %v retry=%v, want throttled", ok, retry)
+	}
+
+	time.Sleep(retry + 5*time.Millisecond)
+	if ok, _, _ := store.Take("k", rate); !ok {
+		t.Error("Take() after refill must succeed")
+	}
+}
+
+func TestRateLimit_SharedStore(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com/")
+	store := incorruptible.NewMemoryRateStore()
+	strict := incorr.RateLimit("login", incorruptible.NewRate(1, time.Hour), incorruptible.LimitByIP(), store)(okHandler)
+	loose := incorr.RateLimit("api", incorruptible.NewRate(100, time.Second), incorruptible.LimitByIP(), store)(okHandler)
+
+	// another instance sharing the store with the same name shares the buckets
+	other := newTestIncorr(t, "https://example.com/")
+	replica := other.RateLimit("login", incorruptible.NewRate(1, time.Hour), incorruptible.LimitByIP(), store)(okHandler)
+
+	serve := func(h http.Handler) int {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+		r.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// the same key in both middlewares: the looser one must not reset the stricter bucket
+	steps := []struct {
+		name    string
+		handler http.Handler
+		want    int
+	}{
+		{"strict #1", strict, http.StatusOK},
+		{"strict #2", strict, http.StatusTooManyRequests},
+		{"loose #1", loose, http.StatusOK},
+		{"loose #2", loose, http.StatusOK},
+		{"strict #3", strict, http.StatusTooManyRequests},
+		{"replica", replica, http.StatusTooManyRequests},
+	}
+	for _, s := range steps {
+		if got := serve(s.handler); got != s.want {
+			t.Errorf("%s: status = %d, want %d", s.name, got, s.want)
+		}
+	}
+}
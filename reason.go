@@ -0,0 +1,159 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Reason is the machine-readable cause of a token rejection.
+// Reason implements the error interface, so the errors returned by
+// this package can be tested with errors.Is(err, ReasonExpired)
+// or converted back with ReasonOf(err).
+type Reason string
+
+const (
+	ReasonMissing           Reason = "missing_token"
+	ReasonMalformed         Reason = "malformed_request"
+	ReasonInvalid           Reason = "invalid_token"
+	ReasonTampered          Reason = "tampered_token"
+	ReasonExpired           Reason = "expired_token"
+	ReasonIPMismatch        Reason = "ip_mismatch"
+	ReasonInsufficientScope Reason = "insufficient_scope"
+	ReasonStaleAuth         Reason = "insufficient_user_authentication" // RFC 9470
+	ReasonRevoked           Reason = "revoked_token"
+	ReasonWrongProfile      Reason = "wrong_profile" // valid token of another Profile
+)
+
+func (reason Reason) Error() string { return string(reason) }
+
+// ReasonOf extracts the Reason from the error chain.
+// ReasonOf returns ReasonInvalid when the error conveys no Reason.
+func ReasonOf(err error) Reason {
+	var reason Reason
+	if errors.As(err, &reason) {
+		return reason
+	}
+	return ReasonInvalid
+}
+
+// StatusCode returns the HTTP status code corresponding to the Reason.
+func (reason Reason) StatusCode() int {
+	switch reason {
+	case ReasonMalformed:
+		return http.StatusBadRequest
+	case ReasonInsufficientScope:
+		return http.StatusForbidden
+	default:
+		return http.StatusUnauthorized
+	}
+}
+
+// errorCode returns the error code defined by RFC 6750 (section 3.1).
+// The error code is empty when the request lacks any authentication information.
+func (reason Reason) errorCode() string {
+	switch reason {
+	case ReasonMissing:
+		return ""
+	case ReasonMalformed:
+		return "invalid_request"
+	case ReasonInsufficientScope:
+		return "insufficient_scope"
+	case ReasonStaleAuth:
+		return "insufficient_user_authentication"
+	default:
+		return "invalid_token"
+	}
+}
+
+// Description returns a human-readable description of the Reason,
+// without any detail about the rejected token.
+func (reason Reason) Description() string {
+	switch reason {
+	case ReasonMissing:
+		return "The request has no token"
+	case ReasonMalformed:
+		return "The request is malformed"
+	case ReasonTampered:
+		return "The token has been altered"
+	case ReasonExpired:
+		return "The token expired"
+	case ReasonIPMismatch:
+		return "The token was issued to another IP"
+	case ReasonInsufficientScope:
+		return "The token lacks the required privileges"
+	case ReasonStaleAuth:
+		return "A more recent authentication is required"
+	case ReasonRevoked:
+		return "The token has been revoked"
+	case ReasonWrongProfile:
+		return "The token is intended for another usage"
+	default:
+		return "The token is invalid"
+	}
+}
+
+// WithRealm sets the realm of the "WWW-Authenticate" challenge.
+// Default is the host of the first URL passed to New.
+func WithRealm(realm string) Option {
+	return func(incorr *Incorruptible) {
+		incorr.realm = realm
+	}
+}
+
+// challenge formats the "WWW-Authenticate" header value (RFC 6750 section 3).
+func (incorr *Incorruptible) challenge(reason Reason) string {
+	var b strings.Builder
+	b.WriteString(incorr.authScheme)
+	b.WriteString(` realm="`)
+	b.WriteString(quotable(incorr.realm))
+	b.WriteByte('"')
+
+	if code := reason.errorCode(); code != "" {
+		b.WriteString(`, error="`)
+		b.WriteString(code)
+		b.WriteString(`", error_description="`)
+		b.WriteString(quotable(reason.Description()))
+		b.WriteByte('"')
+	}
+
+	return b.String()
+}
+
+// quotable drops the characters not allowed within
+// the quoted-string values of RFC 6750 (section 3).
+func quotable(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7E || r == '"' || r == '\\' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// reject responds with the status code derived from the Reason
+// conveyed by the first error of messages (see DecodeToken)
+// and with the corresponding "WWW-Authenticate" challenge.
+func (incorr *Incorruptible) reject(w http.ResponseWriter, r *http.Request, messages ...any) {
+	reason := ReasonInvalid
+	if len(messages) > 0 {
+		if err, ok := messages[0].(error); ok {
+			reason = ReasonOf(err)
+			if reason != ReasonMissing {
+				incorr.log.rejected("reject", r, err)
+			}
+		}
+	}
+
+	if reason.StatusCode() == http.StatusUnauthorized && incorr.redirectLogin(w, r) {
+		return
+	}
+
+	w.Header().Set("WWW-Authenticate", incorr.challenge(reason))
+	incorr.writeErr(w, r, reason.StatusCode(), messages...)
+}
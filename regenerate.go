@@ -0,0 +1,243 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// tokenIDSize is the number of random bytes identifying a token.
+const tokenIDSize = 16
+
+// RevocationStore keeps the identifiers of the revoked tokens until they expire.
+// MemoryStore and DiskStore implement RevocationStore.
+type RevocationStore interface {
+	// Revoke marks the token ID as revoked until the expiry time.
+	Revoke(id string, expires time.Time) error
+	// Revoked returns true when the token ID has been revoked.
+	Revoked(id string) (bool, error)
+}
+
+// MigrateFunc migrates some state of the previous token values
+// (e.g. the shopping cart of an anonymous visitor) to the regenerated ones.
+type MigrateFunc func(r *http.Request, previous TValues, fresh *TValues) error
+
+// WithTokenID sets the key storing a random identifier of the token.
+// A new identifier is generated for every token (NewCookie, the Set middleware, Regenerate…),
+// including the anonymous token revoked by Regenerate at login.
+// The identifier is required to revoke the tokens.
+func WithTokenID(key int) Option {
+	return func(incorr *Incorruptible) {
+		if err := checkWrite(key); err != nil {
+			log.Panic("WithTokenID", err)
+		}
+		incorr.tokenIDKey = key
+	}
+}
+
+// WithCarryOver sets the keys of the values copied by Regenerate
+// from the previous token to the regenerated one. By default, no value is copied.
+func WithCarryOver(keys ...int) Option {
+	return func(incorr *Incorruptible) {
+		for _, k := range keys {
+			if err := checkWrite(k); err != nil {
+				log.Panic("WithCarryOver", err)
+			}
+		}
+		incorr.carryOver = append(incorr.carryOver, keys...)
+	}
+}
+
+// WithRevocationStore enables the revocation of the previous token by Regenerate.
+// The decoded tokens are rejected when their ID is revoked (ReasonRevoked).
+// WithRevocationStore requires WithTokenID.
+func WithRevocationStore(store RevocationStore) Option {
+	return func(incorr *Incorruptible) {
+		incorr.revocations = store
+	}
+}
+
+// WithMigrate sets a hook called by Regenerate after the carry-over of the whitelisted values.
+func WithMigrate(migrate MigrateFunc) Option {
+	return func(incorr *Incorruptible) {
+		incorr.migrate = migrate
+	}
+}
+
+// Regenerate issues a fresh token when the privileges change (login, logout, sudo…)
+// to prevent session fixation. The fresh token has a new ID (see WithTokenID)
+// and only conveys the whitelisted values of the previous token (see WithCarryOver),
+// then the migrate hook (see WithMigrate) and the mutate function customize the fresh values.
+// The previous token is revoked when a RevocationStore is configured,
+// and its spilled values are deleted (see WithSessionStore).
+//
+// Within the Set and Chk middlewares, the fresh token replaces the Session values
+// and the cookie is issued before the response header (see Session).
+// Else, Regenerate sets the cookie in the response header.
+// The mutate function may be nil.
+func (incorr *Incorruptible) Regenerate(w http.ResponseWriter, r *http.Request, mutate func(*TValues)) (TValues, error) {
+	previous, hasPrevious := currentValues(r)
+
+	fresh, err := incorr.NewTValues(r)
+	if err != nil {
+		return fresh, err
+	}
+
+	if hasPrevious {
+		for _, k := range incorr.carryOver {
+			if k == incorr.tokenIDKey {
+				continue // the fresh token keeps its new ID
+			}
+			if k < len(previous.Values) && previous.Values[k] != nil {
+				fresh.set(k, append([]byte(nil), previous.Values[k]...))
+			}
+		}
+	}
+
+	if incorr.migrate != nil {
+		if err = incorr.migrate(r, previous, &fresh); err != nil {
+			return fresh, fmt.Errorf("migrate: %w", err)
+		}
+	}
+
+	if mutate != nil {
+		mutate(&fresh)
+	}
+
+	if s, ok := SessionFromCtx(r); ok {
+		s.regenerate(fresh) // the Session counts the issued token (see Observer)
+	} else {
+		cookie, err := incorr.newCookieFromValues(r, fresh)
+		if err != nil {
+			return fresh, err
+		}
+		if err = incorr.SetCookie(w, r, cookie); err != nil {
+			return fresh, err
+		}
+		incorr.tokenIssued()
+	}
+
+	if hasPrevious {
+		incorr.retire(previous)
+	}
+	return fresh, nil
+}
+
+// newCookieFromValues encodes the values within the cookie profile matching the request.
+func (incorr *Incorruptible) newCookieFromValues(r *http.Request, tv TValues) (*http.Cookie, error) {
+	base, err := incorr.profile(r)
+	if err != nil {
+		return nil, err
+	}
+	token, err := incorr.Encode(tv)
+	if err != nil {
+		return nil, err
+	}
+	return incorr.cookieFromToken(base, token, tv.MaxAge()), nil
+}
+
+// retire revokes the previous token and deletes its spilled values.
+func (incorr *Incorruptible) retire(previous TValues) {
+	if err := incorr.DeleteSession(previous); err != nil {
+		incorr.log.Warn("Regenerate cannot delete the previous session", AttrError, err)
+	}
+
+	if err := incorr.Revoke(previous); err != nil {
+		incorr.log.Warn("Regenerate cannot revoke the previous token", AttrError, err)
+	}
+}
+
+// Revoke revokes the token until its expiry. Revoke does nothing
+// when the token has no ID or when no RevocationStore is configured.
+func (incorr *Incorruptible) Revoke(tv TValues) error {
+	id, ok := incorr.tokenID(tv)
+	if !ok || incorr.revocations == nil {
+		return nil
+	}
+
+	expires := tv.ExpiryTime()
+	if expires.IsZero() {
+		expires = time.Now().Add(DefaultStoreTTL)
+	}
+	if err := incorr.revocations.Revoke(id, expires); err != nil {
+		return err
+	}
+	incorr.tokenRevoked()
+	incorr.auditEvent(nil, AuditRevocation, tv)
+	return nil
+}
+
+// setTokenID stores a new random token ID when WithTokenID is set.
+func (incorr *Incorruptible) setTokenID(tv *TValues) error {
+	if incorr.tokenIDKey < 0 {
+		return nil
+	}
+	id := make([]byte, tokenIDSize)
+	if _, err := crand.Read(id); err != nil {
+		return err
+	}
+	tv.set(incorr.tokenIDKey, id)
+	return nil
+}
+
+func (incorr *Incorruptible) tokenID(tv TValues) (string, bool) {
+	if incorr.tokenIDKey < 0 || incorr.tokenIDKey >= len(tv.Values) || len(tv.Values[incorr.tokenIDKey]) == 0 {
+		return "", false
+	}
+	return hex.EncodeToString(tv.Values[incorr.tokenIDKey]), true
+}
+
+// checkRevoked returns an error wrapping ReasonRevoked when the token ID is revoked.
+func (incorr *Incorruptible) checkRevoked(tv TValues) error {
+	if incorr.revocations == nil {
+		return nil
+	}
+	id, ok := incorr.tokenID(tv)
+	if !ok {
+		return nil
+	}
+	revoked, err := incorr.revocations.Revoked(id)
+	if err != nil {
+		return fmt.Errorf("%w: revocation store: %w", ReasonInvalid, err)
+	}
+	if revoked {
+		return fmt.Errorf("%w: token ID %s", ReasonRevoked, id)
+	}
+	return nil
+}
+
+// Revoke implements RevocationStore.
+func (ms *MemoryStore) Revoke(id string, expires time.Time) error {
+	return ms.Save(id, []byte{}, expires)
+}
+
+// Revoked implements RevocationStore.
+func (ms *MemoryStore) Revoked(id string) (bool, error) {
+	return revoked(ms, id)
+}
+
+// Revoke implements RevocationStore.
+func (ds *DiskStore) Revoke(id string, expires time.Time) error {
+	return ds.Save(id, []byte{}, expires)
+}
+
+// Revoked implements RevocationStore.
+func (ds *DiskStore) Revoked(id string) (bool, error) {
+	return revoked(ds, id)
+}
+
+func revoked(store SessionStore, id string) (bool, error) {
+	_, err := store.Load(id)
+	if errors.Is(err, ErrSessionNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
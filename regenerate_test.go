@@ -0,0 +1,170 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func TestRegenerate(t *testing.T) {
+	t.Parallel()
+
+	const keyID, keyUser, keyLang, keyCart, keyAnon = 0, 1, 2, 3, 4
+
+	migrate := func(_ *http.Request, previous incorruptible.TValues, fresh *incorruptible.TValues) error {
+		cart := previous.StringIfAny(keyCart)
+		return fresh.SetString(keyCart, cart+"+migrated")
+	}
+
+	incorr := newTestIncorr(t, "https://example.com/",
+		incorruptible.WithTokenID(keyID),
+		incorruptible.WithCarryOver(keyLang),
+		incorruptible.WithRevocationStore(incorruptible.NewMemoryStore()),
+		incorruptible.WithMigrate(migrate))
+
+	// anonymous visitor (outside the middlewares)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	anon, err := incorr.Regenerate(w, r, func(tv *incorruptible.TValues) {
+		_ = tv.Set(incorruptible.String(keyLang, "fr"), incorruptible.String(keyCart, "book"), incorruptible.Bool(keyAnon, true))
+	})
+	if err != nil {
+		t.Fatal("Regenerate() error", err)
+	}
+	anonCookies := w.Result().Cookies()
+	if len(anonCookies) != 1 {
+		t.Fatalf("Regenerate() want one cookie, got %d", len(anonCookies))
+	}
+
+	// login
+	login := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := incorr.Regenerate(w, r, func(tv *incorruptible.TValues) {
+			_ = tv.SetString(keyUser, "alice")
+		})
+		if err != nil {
+			t.Error("Regenerate() error", err)
+		}
+	})
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "https://example.com/login", http.NoBody)
+	r.AddCookie(anonCookies[0])
+	incorr.Chk(login).ServeHTTP(w, r)
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("login want one cookie, got %d", len(cookies))
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	r.AddCookie(cookies[0])
+	tv, err := incorr.DecodeCookieToken(r)
+	if err != nil {
+		t.Fatal("DecodeCookieToken() error", err)
+	}
+
+	if bytes.Equal(tv.Values[keyID], anon.Values[keyID]) {
+		t.Error("the regenerated token must have a new ID")
+	}
+	if got := tv.StringIfAny(keyUser); got != "alice" {
+		t.Errorf("user = %q, want alice", got)
+	}
+	if got := tv.StringIfAny(keyLang); got != "fr" {
+		t.Errorf("whitelisted lang = %q, want fr", got)
+	}
+	if got := tv.StringIfAny(keyCart); got != "book+migrated" {
+		t.Errorf("migrated cart = %q, want book+migrated", got)
+	}
+	if tv.BoolIfAny(keyAnon) {
+		t.Error("a non-whitelisted value must not be carried over")
+	}
+
+	// the pre-login token is revoked
+	r = httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	r.AddCookie(anonCookies[0])
+	_, err = incorr.DecodeCookieToken(r)
+	if !errors.Is(err, incorruptible.ReasonRevoked) {
+		t.Errorf("DecodeCookieToken(pre-login) error = %v, want revoked", err)
+	}
+}
+
+func TestLogin_RevokesPreLoginToken(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com/",
+		incorruptible.WithTokenID(0),
+		incorruptible.WithRevocationStore(incorruptible.NewMemoryStore()))
+
+	// the Set middleware hands the anonymous visitor a token
+	w := httptest.NewRecorder()
+	incorr.Set(okHandler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody))
+	anonCookies := w.Result().Cookies()
+	if len(anonCookies) != 1 {
+		t.Fatalf("Set() want one cookie, got %d", len(anonCookies))
+	}
+
+	login := incorr.Chk(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := incorr.Login(w, r, nil); err != nil {
+			t.Error("Login() error", err)
+		}
+	}))
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "https://example.com/login", http.NoBody)
+	r.AddCookie(anonCookies[0])
+	login.ServeHTTP(w, r)
+	if len(w.Result().Cookies()) != 1 || w.Result().Cookies()[0].Value == anonCookies[0].Value {
+		t.Fatalf("Login() want a fresh cookie, got %v", w.Result().Cookies())
+	}
+
+	// replay the pre-login cookie (session fixation)
+	r = httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	r.AddCookie(anonCookies[0])
+	if _, err := incorr.DecodeCookieToken(r); !errors.Is(err, incorruptible.ReasonRevoked) {
+		t.Errorf("DecodeCookieToken() error = %v, want ReasonRevoked", err)
+	}
+}
+
+func TestNew_KeyCollisions(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name string
+		opts []incorruptible.Option
+	}{
+		{"csrf+token ID", []incorruptible.Option{incorruptible.WithCSRF(1), incorruptible.WithTokenID(1)}},
+		{"auth-time+visitor", []incorruptible.Option{incorruptible.WithAuthTimeKey(2), incorruptible.WithVisitorID(2)}},
+		{"carry-over token ID", []incorruptible.Option{incorruptible.WithTokenID(3), incorruptible.WithCarryOver(0, 3)}},
+		{"carry-over csrf", []incorruptible.Option{incorruptible.WithCarryOver(4), incorruptible.WithCSRF(4)}},
+		{"out of range", []incorruptible.Option{incorruptible.WithVisitorID(incorruptible.MaxValues)}},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			defer func() {
+				if recover() == nil {
+					t.Error("New() must panic")
+				}
+			}()
+
+			newTestIncorr(t, "https://example.com/", c.opts...)
+		})
+	}
+
+	// distinct keys and a repeated carry-over key are accepted
+	newTestIncorr(t, "https://example.com/",
+		incorruptible.WithCSRF(0), incorruptible.WithAuthTimeKey(1), incorruptible.WithTokenID(2),
+		incorruptible.WithVisitorID(3), incorruptible.WithCarryOver(4, 5, 4))
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Predicate authorizes the token values, returning nil to accept.
+// The returned error should wrap ReasonInsufficientScope (see Require).
+type Predicate func(TValues) error
+
+// Require is a middleware accepting the requests only if the token values
+// satisfy all the predicates. Require must be placed after the Set, Chk or Vet middleware.
+// The request without token values is rejected with "401 Unauthorized" (ReasonMissing),
+// the unauthorized request with "403 Forbidden" (ReasonInsufficientScope).
+// Both through WriteErr and with a "WWW-Authenticate" challenge (RFC 6750).
+//
+// Example:
+//
+//	admin := incorr.Require(incorruptible.RequireScope(keyScope, "admin"))
+//	router.Handle("/admin", incorr.Chk(admin(handler)))
+func (incorr *Incorruptible) Require(preds ...Predicate) func(http.Handler) http.Handler {
+	pred := RequireAll(preds...)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tv, ok := currentValues(r)
+			if !ok {
+				incorr.reject(w, r, fmt.Errorf("%w: Require needs the Set, Chk or Vet middleware", ReasonMissing))
+				return
+			}
+
+			if err := pred(tv); err != nil {
+				var reason Reason
+				if !errors.As(err, &reason) {
+					err = fmt.Errorf("%w: %w", ReasonInsufficientScope, err)
+				}
+				incorr.reject(w, r, err)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// currentValues returns the token values from the Session (including the
+// modifications done by the previous handlers), else from the request context.
+func currentValues(r *http.Request) (TValues, bool) {
+	if s, ok := SessionFromCtx(r); ok {
+		return s.Values(), true
+	}
+	return FromCtx(r)
+}
+
+// RequireBool requires the boolean value at the given key to be true.
+func RequireBool(key int) Predicate {
+	return func(tv TValues) error {
+		ok, err := tv.Bool(key)
+		if err != nil {
+			return fmt.Errorf("%w: key=%d %w", ReasonInsufficientScope, key, err)
+		}
+		if !ok {
+			return fmt.Errorf("%w: key=%d is false", ReasonInsufficientScope, key)
+		}
+		return nil
+	}
+}
+
+// RequireScope requires the string value at the given key to contain all the scopes.
+// The value is a list of scopes separated by spaces as in OAuth 2.0: "read write admin".
+func RequireScope(key int, scopes ...string) Predicate {
+	return func(tv TValues) error {
+		list, err := tv.String(key)
+		if err != nil {
+			return fmt.Errorf("%w: key=%d %w", ReasonInsufficientScope, key, err)
+		}
+		granted := strings.Fields(list)
+		for _, s := range scopes {
+			if !slices.Contains(granted, s) {
+				return fmt.Errorf("%w: key=%d lacks scope %q", ReasonInsufficientScope, key, s)
+			}
+		}
+		return nil
+	}
+}
+
+// RequireAll requires all the predicates to be satisfied.
+// RequireAll without predicate accepts any token values.
+func RequireAll(preds ...Predicate) Predicate {
+	return func(tv TValues) error {
+		for _, p := range preds {
+			if err := p(tv); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// RequireAny requires at least one of the predicates to be satisfied.
+// RequireAny without predicate rejects any token values.
+func RequireAny(preds ...Predicate) Predicate {
+	return func(tv TValues) error {
+		errs := make([]error, 0, len(preds))
+		for _, p := range preds {
+			err := p(tv)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err)
+		}
+		if len(errs) == 0 {
+			return fmt.Errorf("%w: RequireAny without predicate", ReasonInsufficientScope)
+		}
+		return errors.Join(errs...)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+const (
+	keyAdmin = iota
+	keyScope
+)
+
+func newRequireTValues(t *testing.T, admin bool, scope string) incorruptible.TValues {
+	t.Helper()
+
+	tv, err := incorruptible.NewTValues(incorruptible.Bool(keyAdmin, admin), incorruptible.String(keyScope, scope))
+	if err != nil {
+		t.Fatal("NewTValues() error", err)
+	}
+	return tv
+}
+
+func TestRequirePredicates(t *testing.T) {
+	t.Parallel()
+
+	admin := newRequireTValues(t, true, "read")
+	reader := newRequireTValues(t, false, "read write")
+
+	accept := func(incorruptible.TValues) error { return nil }
+	deny := func(incorruptible.TValues) error { return errors.New("denied") }
+
+	cases := []struct {
+		name string
+		pred incorruptible.Predicate
+		tv   incorruptible.TValues
+		ok   bool
+	}{
+		{"bool true", incorruptible.RequireBool(keyAdmin), admin, true},
+		{"bool false", incorruptible.RequireBool(keyAdmin), reader, false},
+		{"bool missing key", incorruptible.RequireBool(5), admin, false},
+		{"scope granted", incorruptible.RequireScope(keyScope, "write"), reader, true},
+		{"scopes granted", incorruptible.RequireScope(keyScope, "write", "read"), reader, true},
+		{"scope denied", incorruptible.RequireScope(keyScope, "write"), admin, false},
+		{"scope prefix", incorruptible.RequireScope(keyScope, "rea"), admin, false},
+		{"all none", incorruptible.RequireAll(), reader, true},
+		{"all accept", incorruptible.RequireAll(accept, incorruptible.RequireScope(keyScope, "read")), reader, true},
+		{"all one deny", incorruptible.RequireAll(accept, deny), reader, false},
+		{"any none", incorruptible.RequireAny(), reader, false},
+		{"any one accept", incorruptible.RequireAny(deny, incorruptible.RequireBool(keyAdmin)), admin, true},
+		{"any all deny", incorruptible.RequireAny(deny, incorruptible.RequireBool(keyAdmin)), reader, false},
+		{
+			"nested", incorruptible.RequireAny(
+				incorruptible.RequireBool(keyAdmin),
+				incorruptible.RequireAll(incorruptible.RequireScope(keyScope, "write"), accept),
+			), reader, true,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := c.pred(c.tv)
+			if (err == nil) != c.ok {
+				t.Errorf("predicate error = %v, want accepted=%v", err, c.ok)
+			}
+		})
+	}
+}
+
+func TestRequire(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com/")
+	admin := incorr.Require(incorruptible.RequireBool(keyAdmin))
+	handler := incorr.Vet(admin(okHandler))
+
+	cases := []struct {
+		name       string
+		token      bool
+		tv         incorruptible.TValues
+		wantStatus int
+		wantError  string
+	}{
+		{"no token", false, incorruptible.EmptyTValues(), http.StatusUnauthorized, ""},
+		{"admin", true, newRequireTValues(t, true, ""), http.StatusOK, ""},
+		{"not admin", true, newRequireTValues(t, false, ""), http.StatusForbidden, `error="insufficient_scope"`},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/admin", http.NoBody)
+			if c.token {
+				c.tv.SetExpiry(3600)
+				cookie, err := incorr.NewCookieFromValues(c.tv)
+				if err != nil {
+					t.Fatal("NewCookieFromValues() error", err)
+				}
+				r.AddCookie(cookie)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != c.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, c.wantStatus)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); !strings.Contains(got, c.wantError) {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, c.wantError)
+			}
+		})
+	}
+}
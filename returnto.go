@@ -0,0 +1,196 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// RedirectParam is the query parameter conveying the original URL to the login page.
+	RedirectParam = "return_to"
+
+	// ReturnToTTL is the lifetime of the return URL conveyed to the login page.
+	ReturnToTTL = 15 * time.Minute
+
+	// DefaultReturnTo is returned by ReturnTo when no default page is set (see WithLoginRedirect).
+	DefaultReturnTo = "/"
+)
+
+// returnToAD is the additional data binding the token to the return URL usage:
+// a session token cannot be used as a return URL and vice versa.
+//
+//nolint:gochecknoglobals // constant
+var returnToAD = []byte(RedirectParam)
+
+// ErrReturnTo means the return URL is missing, expired, tampered or not allowed.
+var ErrReturnTo = errors.New("invalid return URL")
+
+// WithLoginRedirect redirects the browsers to the login page ("303 See Other")
+// instead of responding "401 Unauthorized" (Chk, Vet in strict mode, Require…, RequireRecentAuth).
+// The "403 Forbidden" rejections (CSRF, Isolate…) are not redirected.
+// Only the GET/HEAD requests preferring "text/html" (see the "Accept" header) are redirected.
+// The original URL is conveyed by the RedirectParam query parameter
+// within a tamper-proof token expiring after ReturnToTTL.
+// The login handler retrieves the original URL with ReturnTo.
+// defaultPage is the URL returned by ReturnTo when the token is missing or invalid.
+func WithLoginRedirect(loginURL, defaultPage string) Option {
+	return func(incorr *Incorruptible) {
+		u, err := url.Parse(loginURL)
+		if err != nil {
+			log.Panic("WithLoginRedirect", err)
+		}
+		if !safeReturnPath(defaultPage) {
+			log.Panicf("WithLoginRedirect: default page %q must be a local path", defaultPage)
+		}
+		incorr.loginURL = u
+		incorr.defaultPage = defaultPage
+	}
+}
+
+// ReturnTo returns the original URL conveyed to the login handler (see WithLoginRedirect),
+// else the default page (DefaultReturnTo without WithLoginRedirect). ReturnTo reads the RedirectParam parameter from the URL query
+// or from the submitted form. The returned URL is either a local path
+// or a URL of one of the allowed origins (URLs passed to New and WithTrustedOrigins).
+func (incorr *Incorruptible) ReturnTo(r *http.Request) string {
+	u, err := incorr.DecodeReturnTo(r.FormValue(RedirectParam))
+	if err != nil {
+		if incorr.defaultPage == "" {
+			return DefaultReturnTo
+		}
+		return incorr.defaultPage
+	}
+	return u
+}
+
+// DecodeReturnTo decodes and validates the RedirectParam value.
+// The returned error wraps ErrReturnTo.
+func (incorr *Incorruptible) DecodeReturnTo(token string) (string, error) {
+	if token == "" {
+		return "", fmt.Errorf("%w: no %s parameter", ErrReturnTo, RedirectParam)
+	}
+
+	tv, err := incorr.decodeAD(token, incorr.scopedAD(returnToAD))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrReturnTo, err)
+	}
+	if tv.Expires == 0 || !tv.ValidExpiry() {
+		return "", fmt.Errorf("%w: %w", ErrReturnTo, ReasonExpired)
+	}
+
+	target, err := tv.String(0)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrReturnTo, err)
+	}
+	if !incorr.allowedReturnTo(target) {
+		return "", fmt.Errorf("%w: %q not allowed", ErrReturnTo, target)
+	}
+	return target, nil
+}
+
+// EncodeReturnTo encodes the URL to convey it to the login page.
+// The URL must be a local path or a URL of an allowed origin.
+func (incorr *Incorruptible) EncodeReturnTo(target string) (string, error) {
+	if !incorr.allowedReturnTo(target) {
+		return "", fmt.Errorf("%w: %q not allowed", ErrReturnTo, target)
+	}
+
+	tv, err := NewTValues(String(0, target))
+	if err != nil {
+		return "", err
+	}
+	tv.SetExpiryDuration(ReturnToTTL)
+	return incorr.encodeAD(tv, incorr.scopedAD(returnToAD))
+}
+
+// RedirectToLogin returns a WriteErr redirecting the unauthenticated requests
+// ("401 Unauthorized") to the login page configured by WithLoginRedirect.
+// The original URL is conveyed within a tamper-proof token (see EncodeReturnTo).
+// The other errors and the requests not eligible to the redirection
+// are written by the fallback (default is the WriteErr passed to New).
+// The middlewares already redirect with WithLoginRedirect:
+// RedirectToLogin is intended for the handlers rejecting the requests by themselves.
+// RedirectToLogin panics without WithLoginRedirect.
+//
+// Example:
+//
+//	incorr.RedirectToLogin(nil)(w, r, http.StatusUnauthorized, "sign in to see your orders")
+func (incorr *Incorruptible) RedirectToLogin(fallback WriteErr) WriteErr {
+	if incorr.loginURL == nil {
+		log.Panic("RedirectToLogin requires WithLoginRedirect")
+	}
+	if fallback == nil {
+		fallback = incorr.writeErr
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, statusCode int, messages ...any) {
+		if statusCode == http.StatusUnauthorized && incorr.redirectLogin(w, r) {
+			return
+		}
+		fallback(w, r, statusCode, messages...)
+	}
+}
+
+// redirectLogin redirects the browser to the login page.
+// redirectLogin returns false when the request is not eligible to the redirection.
+func (incorr *Incorruptible) redirectLogin(w http.ResponseWriter, r *http.Request) bool {
+	if incorr.loginURL == nil || r == nil {
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if !prefersHTML(r.Header.Get("Accept")) {
+		return false
+	}
+
+	target := r.URL.RequestURI()
+	if origin := incorr.requestScheme(r) + "://" + r.Host; incorr.allowedOrigin(origin) {
+		target = origin + target
+	}
+
+	token, err := incorr.EncodeReturnTo(target)
+	if err != nil {
+		incorr.log.Warn("Cannot encode the return URL", AttrPath, r.URL.Path, AttrError, err)
+		return false
+	}
+
+	login := *incorr.loginURL // local copy
+	q := login.Query()
+	q.Set(RedirectParam, token)
+	login.RawQuery = q.Encode()
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Vary", "Accept")
+	http.Redirect(w, r, login.String(), http.StatusSeeOther)
+	return true
+}
+
+// allowedReturnTo returns true for a local path or a URL of an allowed origin.
+func (incorr *Incorruptible) allowedReturnTo(target string) bool {
+	if safeReturnPath(target) {
+		return true
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.User != nil {
+		return false
+	}
+	return incorr.allowedOrigin(u.Scheme + "://" + u.Host)
+}
+
+// safeReturnPath returns true for a local path, rejecting the
+// protocol-relative URLs ("//evil.com") and the backslash variants ("/\evil.com").
+func safeReturnPath(p string) bool {
+	return strings.HasPrefix(p, "/") &&
+		!strings.HasPrefix(p, "//") &&
+		!strings.HasPrefix(p, "/\\") &&
+		!strings.ContainsAny(p, "\r\n")
+}
@@ -0,0 +1,177 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+const browserAccept = "text/html,application/xhtml+xml,*/*;q=0.8"
+
+func TestWithLoginRedirect(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com", incorruptible.WithLoginRedirect("/login", "/home"))
+	handler := incorr.Chk(okHandler)
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/account?tab=2", http.NoBody)
+	r.Header.Set("Accept", browserAccept)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("status %d, want 303", w.Code)
+	}
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || loc.Path != "/login" {
+		t.Fatalf("unexpected Location %v err=%v", loc, err)
+	}
+	token := loc.Query().Get(incorruptible.RedirectParam)
+	if token == "" {
+		t.Fatal("missing", incorruptible.RedirectParam)
+	}
+
+	// the login handler retrieves the original URL
+	r = httptest.NewRequest(http.MethodGet, loc.String(), http.NoBody)
+	if got := incorr.ReturnTo(r); got != "https://example.com/account?tab=2" {
+		t.Errorf("ReturnTo() = %q", got)
+	}
+
+	// the API clients and the non-GET requests are not redirected
+	for _, c := range []struct{ method, accept string }{
+		{http.MethodGet, "application/json"},
+		{http.MethodPost, browserAccept},
+	} {
+		r = httptest.NewRequest(c.method, "https://example.com/account", http.NoBody)
+		r.Header.Set("Accept", c.accept)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s Accept=%q: status %d, want 401", c.method, c.accept, w.Code)
+		}
+	}
+}
+
+func TestRedirectToLogin(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com", incorruptible.WithLoginRedirect("/login", "/home"))
+	writeErr := incorr.RedirectToLogin(nil)
+
+	serve := func(status int) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/orders", http.NoBody)
+		r.Header.Set("Accept", browserAccept)
+		w := httptest.NewRecorder()
+		writeErr(w, r, status, "sign in")
+		return w
+	}
+
+	w := serve(http.StatusUnauthorized)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("status %d, want 303", w.Code)
+	}
+	r := httptest.NewRequest(http.MethodGet, w.Header().Get("Location"), http.NoBody)
+	if got := incorr.ReturnTo(r); got != "https://example.com/orders" {
+		t.Errorf("ReturnTo() = %q", got)
+	}
+
+	// the other errors are written by the fallback
+	if w = serve(http.StatusForbidden); w.Code != http.StatusForbidden {
+		t.Errorf("status %d, want 403", w.Code)
+	}
+
+	// without WithLoginRedirect
+	defer func() {
+		if recover() == nil {
+			t.Error("RedirectToLogin() must panic without WithLoginRedirect")
+		}
+	}()
+	newTestIncorr(t, "https://example.com").RedirectToLogin(nil)
+}
+
+func TestRequireRecentAuth_LoginRedirect(t *testing.T) {
+	t.Parallel()
+
+	const keyAuthTime = 0
+	incorr := newTestIncorr(t, "https://example.com",
+		incorruptible.WithAuthTimeKey(keyAuthTime), incorruptible.WithLoginRedirect("/login", "/home"))
+	handler := incorr.Set(incorr.RequireRecentAuth(time.Minute)(okHandler))
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/withdraw", http.NoBody)
+	r.Header.Set("Accept", browserAccept)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("status %d, want 303", w.Code)
+	}
+	r = httptest.NewRequest(http.MethodGet, w.Header().Get("Location"), http.NoBody)
+	if got := incorr.ReturnTo(r); got != "https://example.com/withdraw" {
+		t.Errorf("ReturnTo() = %q", got)
+	}
+}
+
+func TestReturnTo_Invalid(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com", incorruptible.WithLoginRedirect("/login", "/home"))
+
+	session, err := incorr.Encode(incorruptible.EmptyTValues())
+	if err != nil {
+		t.Fatal("Encode() error", err)
+	}
+	local, err := incorr.EncodeReturnTo("/orders")
+	if err != nil {
+		t.Fatal("EncodeReturnTo() error", err)
+	}
+
+	cases := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"local path", local, "/orders"},
+		{"missing", "", "/home"},
+		{"tampered", local[:len(local)-2] + "AA", "/home"},
+		{"session token", session, "/home"},
+		{"plain URL", "https://evil.com/", "/home"},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/login", http.NoBody)
+		q := r.URL.Query()
+		q.Set(incorruptible.RedirectParam, c.token)
+		r.URL.RawQuery = q.Encode()
+
+		if got := incorr.ReturnTo(r); got != c.want {
+			t.Errorf("%s: ReturnTo() = %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	for _, target := range []string{"https://evil.com/", "//evil.com/", "/\\evil.com", "javascript:alert(1)", "https://user@example.com/"} {
+		if _, err := incorr.EncodeReturnTo(target); !errors.Is(err, incorruptible.ErrReturnTo) {
+			t.Errorf("EncodeReturnTo(%q) must fail, got %v", target, err)
+		}
+	}
+}
+
+func TestReturnTo_DefaultPage(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com") // no WithLoginRedirect
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/login", http.NoBody)
+	if got := incorr.ReturnTo(r); got != incorruptible.DefaultReturnTo {
+		t.Errorf("ReturnTo() = %q, want %q", got, incorruptible.DefaultReturnTo)
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Session is a mutable handle on the token values,
+// stored in the request context by the Set and Chk middlewares.
+// When a handler modifies the Session, the middleware re-issues the cookie
+// just before the response header is written.
+// The modifications must therefore be done before writing the response.
+type Session struct {
+	mu          sync.Mutex
+	tv          TValues
+	changed     bool
+	regenerated bool // fresh token (see Regenerate)
+}
+
+// Values returns a copy of the current token values.
+func (s *Session) Values() TValues {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tv.clone()
+}
+
+// Set modifies some values of the Session.
+func (s *Session) Set(keyValues ...KVal) error {
+	return s.Update(func(tv *TValues) error {
+		return tv.Set(keyValues...)
+	})
+}
+
+// Update modifies the Session using the function f.
+// The Session is unchanged when f returns an error.
+func (s *Session) Update(f func(tv *TValues) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tv := s.tv.clone()
+	if err := f(&tv); err != nil {
+		return err
+	}
+
+	s.tv = tv
+	s.changed = true
+	return nil
+}
+
+// Changed returns true when the Session has been modified since the cookie was issued.
+func (s *Session) Changed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.changed
+}
+
+// regenerate replaces the values by the fresh token values (see Regenerate).
+func (s *Session) regenerate(fresh TValues) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tv = fresh.clone()
+	s.changed = true
+	s.regenerated = true
+}
+
+// takeChanged returns the modified values and resets the changed flags.
+//
+//nolint:nonamedreturns // we want to document the returned values.
+func (s *Session) takeChanged() (tv TValues, changed, regenerated bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed, regenerated = s.changed, s.regenerated
+	s.changed, s.regenerated = false, false
+	return s.tv.clone(), changed, regenerated
+}
+
+// clone returns a copy of the TValues that can be modified without altering the original.
+func (tv TValues) clone() TValues {
+	if tv.Values != nil {
+		tv.Values = append(make([][]byte, 0, len(tv.Values)), tv.Values...)
+	}
+	return tv
+}
+
+type sessionKey struct{}
+
+//nolint:gochecknoglobals // Context access key need to be global variable.
+var sessionContextKey sessionKey
+
+// SessionFromCtx gets the mutable Session from the request context.
+func SessionFromCtx(r *http.Request) (*Session, bool) {
+	s, ok := r.Context().Value(sessionContextKey).(*Session)
+	return s, ok
+}
+
+// serveSession stores the token values and the mutable Session in the request context
+// and wraps the ResponseWriter to issue the cookie before the response header.
+// The pending cookie (if any) is set only if the Session is not modified
+// and if the handler has not set its own cookie (e.g. a login cookie from NewCookie).
+func (incorr *Incorruptible) serveSession(next http.Handler, w http.ResponseWriter, r *http.Request, tv TValues, pending *http.Cookie) {
+	s := &Session{tv: tv}
+
+	r = tv.ToCtx(r)
+	r = r.WithContext(context.WithValue(r.Context(), sessionContextKey, s))
+
+	sw := &sessionWriter{
+		ResponseWriter: w,
+		incorr:         incorr,
+		r:              r,
+		session:        s,
+		pending:        pending,
+	}
+
+	next.ServeHTTP(sw.wrap(), r)
+
+	if !sw.wroteHeader {
+		sw.writeCookie() // handler has written nothing
+	}
+}
+
+// sessionWriter emits the "Set-Cookie" header just before the response header.
+type sessionWriter struct {
+	http.ResponseWriter
+	incorr      *Incorruptible
+	r           *http.Request
+	session     *Session
+	pending     *http.Cookie
+	wroteHeader bool
+}
+
+func (sw *sessionWriter) WriteHeader(statusCode int) {
+	// informational responses (except "101 Switching Protocols") precede the final header
+	informational := statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols
+	if !sw.wroteHeader && !informational {
+		sw.writeCookie()
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sw *sessionWriter) Write(buf []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	return sw.ResponseWriter.Write(buf)
+}
+
+// FlushError is used by http.ResponseController: the cookie is set before flushing.
+func (sw *sessionWriter) FlushError() error {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	return http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Unwrap is used by http.ResponseController.
+func (sw *sessionWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+func (sw *sessionWriter) flush() {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	sw.ResponseWriter.(http.Flusher).Flush()
+}
+
+// hijack: the Session modifications are lost after the connection is hijacked.
+func (sw *sessionWriter) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+// The wrappers below implement http.Flusher and http.Hijacker
+// only when the underlying ResponseWriter does:
+// the handlers may check these interfaces to select streaming or a websocket upgrade.
+type (
+	flushWriter       struct{ *sessionWriter }
+	hijackWriter      struct{ *sessionWriter }
+	flushHijackWriter struct{ *sessionWriter }
+)
+
+func (w flushWriter) Flush()       { w.flush() }
+func (w flushHijackWriter) Flush() { w.flush() }
+
+func (w hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error)      { return w.hijack() }
+func (w flushHijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) { return w.hijack() }
+
+// wrap returns the sessionWriter implementing the same optional interfaces
+// as the underlying ResponseWriter.
+func (sw *sessionWriter) wrap() http.ResponseWriter {
+	_, flusher := sw.ResponseWriter.(http.Flusher)
+	_, hijacker := sw.ResponseWriter.(http.Hijacker)
+	switch {
+	case flusher && hijacker:
+		return flushHijackWriter{sw}
+	case flusher:
+		return flushWriter{sw}
+	case hijacker:
+		return hijackWriter{sw}
+	default:
+		return sw
+	}
+}
+
+func (sw *sessionWriter) writeCookie() {
+	cookie := sw.pending
+	sw.pending = nil
+
+	if cookie != nil && hasSetCookie(sw.ResponseWriter, cookie.Name) {
+		cookie = nil // the cookie set by the handler wins
+	}
+
+	if tv, changed, regenerated := sw.session.takeChanged(); changed {
+		var err error
+		cookie, err = sw.incorr.newCookieFromValues(sw.r, tv)
+		if err != nil {
+			sw.incorr.log.Warn("Session cannot re-issue the cookie", AttrError, err)
+			return
+		}
+		if regenerated {
+			sw.incorr.tokenIssued()
+		} else {
+			sw.incorr.tokenRefreshed()
+		}
+	}
+
+	if cookie != nil {
+		err := sw.incorr.SetCookie(sw.ResponseWriter, sw.r, cookie)
+		if err != nil {
+			sw.incorr.log.Warn("Session cannot set the cookie", AttrError, err)
+		}
+	}
+}
+
+// hasSetCookie returns true when the response already sets the named cookie.
+func hasSetCookie(w http.ResponseWriter, name string) bool {
+	for _, line := range w.Header().Values("Set-Cookie") {
+		if c, err := http.ParseSetCookie(line); err == nil && c.Name == name {
+			return true
+		}
+	}
+	return false
+}
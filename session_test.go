@@ -0,0 +1,147 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func TestSession_WriteBack(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com/")
+
+	handler := incorr.Set(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("visit") {
+			s, ok := incorruptible.SessionFromCtx(r)
+			if !ok {
+				t.Fatal("SessionFromCtx() no Session")
+			}
+			tv := s.Values()
+			n := tv.Uint64IfAny(0)
+			if err := s.Set(incorruptible.Uint64(0, n+1)); err != nil {
+				t.Error("Session.Set() error", err)
+			}
+		}
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("ResponseWriter must implement http.Flusher")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func(target string, cookies []*http.Cookie) []*http.Cookie {
+		r := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Result().Cookies()
+	}
+
+	first := serve("https://example.com/", nil)
+	if len(first) != 1 {
+		t.Fatalf("want 1 cookie for a new visitor, got %d", len(first))
+	}
+
+	if got := serve("https://example.com/", first); len(got) != 0 {
+		t.Errorf("want no cookie when the Session is unchanged, got %d", len(got))
+	}
+
+	second := serve("https://example.com/?visit", first)
+	if len(second) != 1 {
+		t.Fatalf("want 1 cookie when the Session is modified, got %d", len(second))
+	}
+
+	third := serve("https://example.com/?visit", second)
+	if len(third) != 1 {
+		t.Fatalf("want 1 cookie when the Session is modified, got %d", len(third))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	r.AddCookie(third[0])
+	tv, err := incorr.DecodeCookieToken(r)
+	if err != nil {
+		t.Fatal("DecodeCookieToken() error", err)
+	}
+	if n := tv.Uint64IfAny(0); n != 2 {
+		t.Errorf("Session counter = %d, want 2", n)
+	}
+}
+
+func TestSession_HandlerCookieWins(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com/")
+
+	handler := incorr.Set(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, _, err := incorr.NewCookie(r, incorruptible.String(0, "alice"))
+		if err != nil {
+			t.Fatal("NewCookie() error", err)
+		}
+		http.SetCookie(w, cookie)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	// new visitor: Set has a pending anonymous cookie
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/login", http.NoBody)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("want only the login cookie, got %d cookies", len(cookies))
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	r.AddCookie(cookies[0])
+	tv, err := incorr.DecodeCookieToken(r)
+	if err != nil {
+		t.Fatal("DecodeCookieToken() error", err)
+	}
+	if s := tv.StringIfAny(0); s != "alice" {
+		t.Errorf("want the login cookie (alice), got %q", s)
+	}
+}
+
+// plainWriter implements neither http.Flusher nor http.Hijacker.
+type plainWriter struct{ http.ResponseWriter }
+
+func TestSession_OptionalInterfaces(t *testing.T) {
+	t.Parallel()
+
+	incorr := newTestIncorr(t, "https://example.com/")
+
+	var flusher, hijacker bool
+	var flushErr error
+	handler := incorr.Set(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, flusher = w.(http.Flusher)
+		_, hijacker = w.(http.Hijacker)
+		flushErr = http.NewResponseController(w).Flush()
+	}))
+
+	// httptest.ResponseRecorder is a Flusher but not a Hijacker
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if !flusher || hijacker || flushErr != nil {
+		t.Errorf("Flusher=%v Hijacker=%v Flush() error %v, want true false nil", flusher, hijacker, flushErr)
+	}
+	if !w.Flushed || len(w.Result().Cookies()) == 0 {
+		t.Errorf("Flush() must set the cookie and flush, flushed=%v cookies=%v", w.Flushed, w.Result().Cookies())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(plainWriter{w}, r)
+	if flusher || hijacker || !errors.Is(flushErr, http.ErrNotSupported) {
+		t.Errorf("Flusher=%v Hijacker=%v Flush() error %v, want false false ErrNotSupported", flusher, hijacker, flushErr)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SignedURLParam is the query parameter conveying the token of a signed URL.
+const SignedURLParam = "sig"
+
+// BoundParamsParam is the query parameter listing the names
+// of the query parameters bound to the token of a signed URL.
+const BoundParamsParam = "sig_params"
+
+// WithSignedURLClock sets the clock used by SignURL and VerifyURL
+// to compute and check the expiry of the signed URLs.
+// Default is time.Now. This option is mainly intended for tests.
+func WithSignedURLClock(now func() time.Time) Option {
+	return func(incorr *Incorruptible) {
+		incorr.clock = now
+	}
+}
+
+func (incorr *Incorruptible) now() time.Time {
+	if incorr.clock == nil {
+		return time.Now()
+	}
+	return incorr.clock()
+}
+
+// SignURL returns a copy of the URL with an additional query parameter
+// containing an Incorruptible token expiring after ttl.
+// The expiry is rounded up to the token precision (PrecisionInSeconds).
+// The token is bound to the HTTP method, to the URL path
+// and to the query parameters named in bound.
+// A bound parameter absent from the URL is bound as absent:
+// VerifyURL rejects the request adding it.
+// The other query parameters are not protected,
+// they can be added, changed or removed without invalidating the URL.
+// The optional values are conveyed within the token
+// and are available in the request context of the VerifyURL middleware.
+//
+// Example:
+//
+//	u, _ := url.Parse("https://example.com/files/report.pdf?user=42")
+//	signed, err := incorr.SignURL(u, http.MethodGet, 10*time.Minute, []string{"user"})
+func (incorr *Incorruptible) SignURL(u *url.URL, method string, ttl time.Duration, bound []string, values ...KVal) (*url.URL, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("signed URL requires a positive TTL, got %v", ttl)
+	}
+
+	query := u.Query()
+	if query.Has(SignedURLParam) || query.Has(BoundParamsParam) {
+		return nil, errors.New("URL already contains the query parameter '" + SignedURLParam + "' or '" + BoundParamsParam + "'")
+	}
+
+	for _, name := range bound {
+		if name == "" || strings.Contains(name, ",") {
+			return nil, fmt.Errorf("invalid bound query parameter name %q", name)
+		}
+	}
+
+	tv, err := NewTValues(values...)
+	if err != nil {
+		return nil, err
+	}
+	// round up: the token truncates the expiry to its precision
+	tv.Expires = incorr.now().Add(ttl).Unix() + PrecisionInSeconds - 1
+
+	token, err := incorr.encodeAD(tv, incorr.scopedAD(urlAdditionalData(method, u.Path, bound, query)))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bound) > 0 {
+		query.Set(BoundParamsParam, strings.Join(bound, ","))
+	}
+	query.Set(SignedURLParam, token)
+
+	signed := *u // local copy
+	signed.RawQuery = query.Encode()
+	return &signed, nil
+}
+
+// VerifyURL is a middleware accepting only the requests
+// having a valid token generated by SignURL.
+// VerifyURL rejects tampered paths and query parameters,
+// expired links and requests using another HTTP method.
+// VerifyURL finally stores the decoded token in the request context.
+func (incorr *Incorruptible) VerifyURL(next http.Handler) http.Handler {
+	incorr.log.Security("Middleware", AttrEvent, "middleware", "name", "VerifyURL", "param", SignedURLParam)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tv, err := incorr.DecodeURL(r)
+		if err != nil {
+			incorr.writeErr(w, r, http.StatusForbidden, err)
+			return
+		}
+		next.ServeHTTP(w, tv.ToCtx(r))
+	})
+}
+
+// DecodeURL decodes and verifies the token of a signed URL.
+func (incorr *Incorruptible) DecodeURL(r *http.Request) (TValues, error) {
+	query := r.URL.Query()
+
+	tokens := query[SignedURLParam]
+	switch len(tokens) {
+	case 0:
+		return TValues{}, errors.New("missing query parameter '" + SignedURLParam + "'")
+	case 1:
+	default:
+		return TValues{}, fmt.Errorf("want one query parameter '%s' but got %d", SignedURLParam, len(tokens))
+	}
+	var bound []string
+	switch names := query[BoundParamsParam]; len(names) {
+	case 0:
+	case 1:
+		bound = strings.Split(names[0], ",")
+	default:
+		return TValues{}, fmt.Errorf("want at most one query parameter '%s' but got %d", BoundParamsParam, len(names))
+	}
+
+	tv, err := incorr.decodeAD(tokens[0], incorr.scopedAD(urlAdditionalData(r.Method, r.URL.Path, bound, query)))
+	if err != nil {
+		return tv, fmt.Errorf("tampered signed URL or unexpected method: %w", err)
+	}
+
+	if tv.Expires == 0 {
+		return tv, errors.New("signed URL without expiry")
+	}
+	if tv.compareExpiryAt(incorr.now().Unix()) != 0 {
+		return tv, fmt.Errorf("%w: signed URL expired at %v", ReasonExpired, tv.ExpiryTime())
+	}
+	return tv, tv.ValidIP(r)
+}
+
+// urlAdditionalData binds the token to the method, the path
+// and the bound query parameters (names and values).
+// The bound names are kept in the given order
+// because they are conveyed as is in the BoundParamsParam.
+func urlAdditionalData(method, path string, bound []string, query url.Values) []byte {
+	if path == "" {
+		path = "/"
+	}
+
+	ad := make([]byte, 0, 64)
+	ad = append(ad, "url\x00"...)
+	ad = append(ad, strings.ToUpper(method)...)
+	ad = append(ad, 0)
+	ad = append(ad, path...)
+	for _, name := range bound {
+		ad = append(ad, 0)
+		ad = append(ad, url.QueryEscape(name)...)
+		for _, v := range query[name] {
+			ad = append(ad, '=')
+			ad = append(ad, url.QueryEscape(v)...)
+		}
+	}
+	return ad
+}
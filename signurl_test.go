@@ -0,0 +1,131 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func TestSignURL(t *testing.T) {
+	t.Parallel()
+
+	u, err := url.Parse("https://example.com/")
+	if err != nil {
+		t.Fatal("url.Parse() error", err)
+	}
+
+	secretKey := []byte("1234567890" + "123456")
+	incorr := incorruptible.New(nil, []*url.URL{u}, secretKey, "session", 0, false)
+
+	file, err := url.Parse("https://example.com/files/report.pdf?user=42")
+	if err != nil {
+		t.Fatal("url.Parse() error", err)
+	}
+
+	signed, err := incorr.SignURL(file, http.MethodGet, time.Minute, []string{"user", "admin"}, incorruptible.String(0, "bob"))
+	if err != nil {
+		t.Fatal("SignURL() error", err)
+	}
+
+	expired, err := incorr.SignURL(file, http.MethodGet, -time.Minute, nil)
+	if err == nil {
+		t.Error("SignURL() want error for negative TTL, got", expired)
+	}
+
+	tampered := func(f func(u *url.URL)) string {
+		cp := *signed
+		f(&cp)
+		return cp.String()
+	}
+
+	cases := []struct {
+		name    string
+		method  string
+		url     string
+		wantErr bool
+	}{
+		{"valid", http.MethodGet, signed.String(), false},
+		{"method", http.MethodDelete, signed.String(), true},
+		{"path", http.MethodGet, tampered(func(u *url.URL) { u.Path = "/files/secret.pdf" }), true},
+		{"query", http.MethodGet, tampered(func(u *url.URL) {
+			q := u.Query()
+			q.Set("user", "43")
+			u.RawQuery = q.Encode()
+		}), true},
+		{"extra", http.MethodGet, tampered(func(u *url.URL) { u.RawQuery += "&admin=1" }), true},
+		{"unbound", http.MethodGet, tampered(func(u *url.URL) { u.RawQuery += "&utm_source=mail" }), false},
+		{"bound", http.MethodGet, tampered(func(u *url.URL) {
+			q := u.Query()
+			q.Set(incorruptible.BoundParamsParam, "admin")
+			u.RawQuery = q.Encode()
+		}), true},
+		{"unsigned", http.MethodGet, file.String(), true},
+	}
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(c.method, c.url, http.NoBody)
+
+			tv, err := incorr.DecodeURL(r)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("DecodeURL() error = %v, wantErr %v", err, c.wantErr)
+			}
+			if c.wantErr {
+				return
+			}
+
+			if s := tv.StringIfAny(0); s != "bob" {
+				t.Errorf("DecodeURL() got value %q, want %q", s, "bob")
+			}
+		})
+	}
+}
+
+func TestSignURL_Expired(t *testing.T) {
+	t.Parallel()
+
+	u, err := url.Parse("https://example.com/")
+	if err != nil {
+		t.Fatal("url.Parse() error", err)
+	}
+
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	secretKey := []byte("1234567890" + "123456")
+	incorr := incorruptible.New(nil, []*url.URL{u}, secretKey, "session", 0, false,
+		incorruptible.WithSignedURLClock(clock))
+
+	// a TTL shorter than the token precision must not be expired at issuance
+	file := &url.URL{Scheme: "https", Host: "example.com", Path: "/files/report.pdf"}
+	signed, err := incorr.SignURL(file, http.MethodGet, time.Second, nil)
+	if err != nil {
+		t.Fatal("SignURL() error", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, signed.String(), http.NoBody)
+	if _, err = incorr.DecodeURL(r); err != nil {
+		t.Fatal("DecodeURL() error before expiry", err)
+	}
+
+	now = now.Add(time.Second + incorruptible.PrecisionInSeconds*time.Second)
+
+	_, err = incorr.DecodeURL(r)
+	if !errors.Is(err, incorruptible.ReasonExpired) {
+		t.Errorf("DecodeURL() after expiry: want ReasonExpired, got %v", err)
+	}
+}
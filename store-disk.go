@@ -0,0 +1,185 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DiskStore is a SessionStore keeping each session in a file
+// within a local directory. The file starts with the expiry time
+// (Unix seconds, 8 bytes big-endian) followed by the session data.
+// The session data is not encrypted: the directory should be private.
+type DiskStore struct {
+	dir       string
+	mu        sync.Mutex
+	lastPurge time.Time
+	log       *logger // logger of the Incorruptible using the store (see WithLogger)
+}
+
+// NewDiskStore creates the directory (if missing) and returns a SessionStore using it.
+func NewDiskStore(dir string) (*DiskStore, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskStore{dir: dir, lastPurge: time.Now(), log: log}, nil
+}
+
+func (ds *DiskStore) Load(id string) ([]byte, error) {
+	file, err := ds.file(id)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 8 {
+		return nil, fmt.Errorf("truncated session file %s", file)
+	}
+
+	expires := int64(binary.BigEndian.Uint64(buf))
+	if time.Now().Unix() > expires {
+		_ = os.Remove(file)
+		return nil, ErrSessionNotFound
+	}
+
+	return buf[8:], nil
+}
+
+// Save writes the session in a temporary file, then renames it
+// so that a concurrent Load never reads a partially written session.
+func (ds *DiskStore) Save(id string, data []byte, expires time.Time) error {
+	file, err := ds.file(id)
+	if err != nil {
+		return err
+	}
+
+	ds.purgeIfDue()
+
+	buf := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(buf, uint64(expires.Unix()))
+	buf = append(buf, data...)
+
+	tmp, err := os.CreateTemp(ds.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(buf)
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (ds *DiskStore) Delete(id string) error {
+	file, err := ds.file(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Purge removes the expired sessions. Purge is also called periodically by Save.
+func (ds *DiskStore) Purge() error {
+	entries, err := os.ReadDir(ds.dir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, e := range entries {
+		if e.IsDir() || !isHex(e.Name()) {
+			continue
+		}
+		file := filepath.Join(ds.dir, e.Name())
+		buf, err := readHead(file, 8)
+		if err != nil || len(buf) < 8 || now > int64(binary.BigEndian.Uint64(buf)) {
+			_ = os.Remove(file)
+		}
+	}
+	return nil
+}
+
+func (ds *DiskStore) purgeIfDue() {
+	ds.mu.Lock()
+	now := time.Now()
+	due := now.Sub(ds.lastPurge) > purgePeriod
+	if due {
+		ds.lastPurge = now
+	}
+	l := ds.log
+	ds.mu.Unlock()
+
+	if due {
+		if err := ds.Purge(); err != nil {
+			if l == nil {
+				l = log // DiskStore created without NewDiskStore
+			}
+			l.Warn("DiskStore purge", AttrError, err)
+		}
+	}
+}
+
+// setLogger is called by New to use the logger of the Incorruptible (see WithLogger).
+func (ds *DiskStore) setLogger(l *logger) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.log = l
+}
+
+// file returns the file path of the session ID.
+// The session ID must be hexadecimal to prevent path traversal.
+func (ds *DiskStore) file(id string) (string, error) {
+	if !isHex(id) {
+		return "", fmt.Errorf("session ID must be hexadecimal, got len=%d", len(id))
+	}
+	return filepath.Join(ds.dir, id), nil
+}
+
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func readHead(file string, n int) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, n)
+	n, err = f.Read(buf)
+	return buf[:n], err
+}
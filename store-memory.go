@@ -0,0 +1,89 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"sync"
+	"time"
+)
+
+// purgePeriod is the minimum duration between two purges of the expired sessions.
+const purgePeriod = time.Minute
+
+// MemoryStore is a SessionStore keeping the sessions in memory.
+// The sessions are lost when the process stops.
+type MemoryStore struct {
+	mu        sync.Mutex
+	sessions  map[string]memorySession
+	lastPurge time.Time
+}
+
+type memorySession struct {
+	expires time.Time
+	data    []byte
+}
+
+// NewMemoryStore creates an empty in-memory SessionStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions:  make(map[string]memorySession),
+		lastPurge: time.Now(),
+	}
+}
+
+func (ms *MemoryStore) Load(id string) ([]byte, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	s, ok := ms.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	if time.Now().After(s.expires) {
+		delete(ms.sessions, id)
+		return nil, ErrSessionNotFound
+	}
+	return s.data, nil
+}
+
+func (ms *MemoryStore) Save(id string, data []byte, expires time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(ms.lastPurge) > purgePeriod {
+		ms.purge(now)
+	}
+
+	ms.sessions[id] = memorySession{
+		expires: expires,
+		data:    append([]byte(nil), data...),
+	}
+	return nil
+}
+
+func (ms *MemoryStore) Delete(id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.sessions, id)
+	return nil
+}
+
+// Len returns the number of sessions, including the expired ones not yet purged.
+func (ms *MemoryStore) Len() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return len(ms.sessions)
+}
+
+func (ms *MemoryStore) purge(now time.Time) {
+	for id, s := range ms.sessions {
+		if now.After(s.expires) {
+			delete(ms.sessions, id)
+		}
+	}
+	ms.lastPurge = now
+}
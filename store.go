@@ -0,0 +1,192 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultTokenBudget is the default maximum size of the BasE91 token
+	// before spilling the values to the SessionStore.
+	// Browsers limit a cookie to about 4 KB including its name and attributes.
+	DefaultTokenBudget = 3000
+
+	// DefaultStoreTTL is the lifetime of the spilled values
+	// when the token has no expiry (session cookie).
+	DefaultStoreTTL = 24 * time.Hour
+
+	sessionIDSize  = 16
+	spillThreshold = 32   // values larger than 32 bytes are spilled first
+	spillMask      = 0xFF // spilled tokens use another magic code
+)
+
+// ErrSessionNotFound is returned by a SessionStore when the session is unknown or expired.
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionStore keeps server-side the token values too large for a cookie.
+// The token conveys the session ID and the small values only.
+// The implementations must be safe for concurrent use.
+type SessionStore interface {
+	// Load returns the data saved for the session ID, or ErrSessionNotFound.
+	Load(id string) ([]byte, error)
+	// Save stores the data until the expiry time.
+	Save(id string, data []byte, expires time.Time) error
+	// Delete removes the session data, if any.
+	Delete(id string) error
+}
+
+// WithSessionStore enables spilling the token values to the SessionStore
+// when the encoded token would exceed the budget (in bytes).
+// A budget of zero means DefaultTokenBudget.
+func WithSessionStore(store SessionStore, budget int) Option {
+	return func(incorr *Incorruptible) {
+		if budget <= 0 {
+			budget = DefaultTokenBudget
+		}
+		if budget < Base91MinSize+2*sessionIDSize {
+			log.Panicf("WithSessionStore budget=%d is too small to convey a session ID", budget)
+		}
+		incorr.store = store
+		incorr.budget = budget
+	}
+}
+
+// DeleteSession removes the spilled values of the token, if any.
+func (incorr *Incorruptible) DeleteSession(tv TValues) error {
+	if incorr.store == nil || tv.sid == nil {
+		return nil
+	}
+	return incorr.store.Delete(hex.EncodeToString(tv.sid))
+}
+
+func (incorr *Incorruptible) spillMagic() uint8 {
+	return incorr.magic ^ spillMask
+}
+
+// spill encodes a token conveying the session ID and saves the other values in the SessionStore.
+// The large values are spilled first, then all values if the token still exceeds the budget.
+func (incorr *Incorruptible) spill(tv TValues, additionalData []byte) (string, error) {
+	sid := tv.sid
+	if sid == nil {
+		sid = make([]byte, sessionIDSize)
+		if _, err := crand.Read(sid); err != nil {
+			return "", err
+		}
+	}
+
+	for _, threshold := range []int{spillThreshold, -1} {
+		token, data, err := splitValues(tv, sid, threshold)
+		if err != nil {
+			return "", err
+		}
+
+		str, err := incorr.encodeMagic(token, incorr.spillMagic(), additionalData)
+		if err != nil || len(str) > incorr.budget {
+			continue
+		}
+
+		expires := tv.ExpiryTime()
+		if expires.IsZero() {
+			expires = time.Now().Add(DefaultStoreTTL)
+		}
+
+		err = incorr.store.Save(hex.EncodeToString(sid), data, expires)
+		if err != nil {
+			return "", fmt.Errorf("SessionStore: %w", err)
+		}
+		return str, nil
+	}
+
+	return "", fmt.Errorf("token exceeds the budget of %d bytes even with the SessionStore", incorr.budget)
+}
+
+// splitValues returns the token values and the data to store.
+// The token values keep the values up to threshold bytes at their index
+// (the spilled ones are empty), and ends with the session ID.
+// A negative threshold spills all values.
+// The data starts with the number of values,
+// followed by the spilled values: index (1 byte), length (2 bytes) and bytes.
+func splitValues(tv TValues, sid []byte, threshold int) (TValues, []byte, error) {
+	token := TValues{Expires: tv.Expires, IP: tv.IP, Values: nil}
+	data := []byte{byte(len(tv.Values))}
+
+	for i, v := range tv.Values {
+		if len(v) <= threshold {
+			token.Values = append(token.Values, v)
+			continue
+		}
+		if threshold >= 0 {
+			token.Values = append(token.Values, nil)
+		}
+		if len(v) > 0xFFFF {
+			return token, nil, fmt.Errorf("value #%d too large for the SessionStore: %d > %d", i, len(v), 0xFFFF)
+		}
+		data = append(data, byte(i), byte(len(v)), byte(len(v)>>8))
+		data = append(data, v...)
+	}
+
+	token.Values = append(token.Values, sid)
+	return token, data, nil
+}
+
+// loadSpilled restores the values saved in the SessionStore.
+func (incorr *Incorruptible) loadSpilled(token TValues) (TValues, error) {
+	n := len(token.Values)
+	if n == 0 {
+		return token, fmt.Errorf("%w: spilled token without session ID", ReasonInvalid)
+	}
+
+	sid := token.Values[n-1]
+	inline := token.Values[:n-1]
+
+	data, err := incorr.store.Load(hex.EncodeToString(sid))
+	if err != nil {
+		return token, fmt.Errorf("%w: SessionStore: %w", ReasonInvalid, err)
+	}
+
+	values, err := mergeValues(inline, data)
+	if err != nil {
+		return token, fmt.Errorf("%w: SessionStore: %w", ReasonInvalid, err)
+	}
+
+	return TValues{Expires: token.Expires, IP: token.IP, Values: values, sid: sid}, nil
+}
+
+// mergeValues is the reverse of splitValues.
+func mergeValues(inline [][]byte, data []byte) ([][]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty session data")
+	}
+
+	total := int(data[0])
+	if len(inline) > total {
+		return nil, fmt.Errorf("token has %d values but session data has %d", len(inline), total)
+	}
+
+	values := make([][]byte, total)
+	copy(values, inline)
+
+	for data = data[1:]; len(data) > 0; {
+		if len(data) < 3 {
+			return nil, fmt.Errorf("truncated session data: %d bytes", len(data))
+		}
+		i := int(data[0])
+		size := int(data[1]) | int(data[2])<<8
+		data = data[3:]
+		if i >= total || size > len(data) {
+			return nil, fmt.Errorf("corrupted session data at value #%d", i)
+		}
+		values[i] = data[:size]
+		data = data[size:]
+	}
+
+	return values, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func TestSessionStore(t *testing.T) {
+	t.Parallel()
+
+	disk, err := incorruptible.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal("NewDiskStore() error", err)
+	}
+
+	stores := []struct {
+		name  string
+		store incorruptible.SessionStore
+	}{
+		{"memory", incorruptible.NewMemoryStore()},
+		{"disk", disk},
+	}
+
+	cart := strings.Repeat("item-123456789,", 400) // 6000 bytes
+
+	cases := []struct {
+		name    string
+		values  [][]byte
+		spilled bool
+	}{
+		{"small", [][]byte{[]byte("a"), []byte("b")}, false},
+		{"large value", [][]byte{[]byte("user"), []byte(cart), nil, []byte("fr")}, true},
+		{"many values", bytes.Split(bytes.Repeat([]byte("123456789-123456789-123456789-123456789-|"), 31), []byte("|")), true},
+	}
+
+	for _, s := range stores {
+		incorr := newTestIncorr(t, "https://example.com/", incorruptible.WithSessionStore(s.store, 200))
+
+		for _, c := range cases {
+			s, c := s, c
+
+			t.Run(s.name+" "+c.name, func(t *testing.T) {
+				t.Parallel()
+
+				tv := incorruptible.TValues{Values: c.values}
+				tv.SetExpiry(3600)
+
+				token, err := incorr.Encode(tv)
+				if err != nil {
+					t.Fatal("Encode() error", err)
+				}
+				if len(token) > 200 {
+					t.Errorf("token length %d exceeds the budget", len(token))
+				}
+
+				got, err := incorr.Decode(token)
+				if err != nil {
+					t.Fatal("Decode() error", err)
+				}
+				if len(got.Values) != len(c.values) {
+					t.Fatalf("Decode() got %d values, want %d", len(got.Values), len(c.values))
+				}
+				for i := range c.values {
+					if !bytes.Equal(got.Values[i], c.values[i]) {
+						t.Errorf("value #%d mismatch got len=%d want len=%d", i, len(got.Values[i]), len(c.values[i]))
+					}
+				}
+
+				err = incorr.DeleteSession(got)
+				if err != nil {
+					t.Error("DeleteSession() error", err)
+				}
+				_, err = incorr.Decode(token)
+				if (err != nil) != c.spilled {
+					t.Errorf("Decode() after DeleteSession() error = %v, want error %v", err, c.spilled)
+				}
+			})
+		}
+	}
+}
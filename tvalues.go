@@ -25,6 +25,7 @@ type TValues struct {
 	Expires int64  // Unix time UTC (seconds since 1970)
 	IP      net.IP // TOTO: use netip.Addr
 	Values  [][]byte
+	sid     []byte // session ID when values are spilled to the SessionStore
 }
 
 // EmptyTValues returns an empty TValues that can be used to generate a minimalist token.
@@ -80,8 +81,8 @@ func (tv *TValues) SetRemoteIP(r *http.Request) error {
 
 func (tv TValues) Valid(r *http.Request) error {
 	if !tv.ValidExpiry() {
-		return fmt.Errorf("expired or malformed or date in the far future: %ds %v",
-			tv.Expires, time.Unix(tv.Expires, 0))
+		return fmt.Errorf("%w: expired or malformed or date in the far future: %ds %v",
+			ReasonExpired, tv.Expires, time.Unix(tv.Expires, 0))
 	}
 	return tv.ValidIP(r)
 }
@@ -101,10 +102,10 @@ func (tv TValues) ValidIP(r *http.Request) error {
 
 	ip, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return fmt.Errorf("checking token but %w", err)
+		return fmt.Errorf("%w: checking token but %w", ReasonInvalid, err)
 	}
 	if !tv.IP.Equal(net.ParseIP(ip)) {
-		return fmt.Errorf("token says IP=%v but got %v", tv.IP, ip)
+		return fmt.Errorf("%w: token says IP=%v but got %v", ReasonIPMismatch, tv.IP, ip)
 	}
 
 	return nil
@@ -130,7 +131,10 @@ func (tv *TValues) ShortenIP4Length() {
 }
 
 func (tv TValues) CompareExpiry() int {
-	now := time.Now().Unix()
+	return tv.compareExpiryAt(time.Now().Unix())
+}
+
+func (tv TValues) compareExpiryAt(now int64) int {
 	if tv.Expires < now {
 		return -1
 	}
@@ -158,3 +162,22 @@ func FromCtx(r *http.Request) (TValues, bool) {
 	tv, ok := r.Context().Value(contextKey).(TValues)
 	return tv, ok
 }
+
+type errKey struct{}
+
+//nolint:gochecknoglobals // Context access key need to be global variable.
+var errContextKey errKey
+
+func errToCtx(r *http.Request, err error) *http.Request {
+	parent := r.Context()
+	child := context.WithValue(parent, errContextKey, err)
+	return r.WithContext(child)
+}
+
+// ErrFromCtx gets the decoding error stored in the request context by the Vet middleware
+// (or by Chk in dev. mode) when the request has no valid token.
+// Use ReasonOf to get the cause of the rejection.
+func ErrFromCtx(r *http.Request) error {
+	err, _ := r.Context().Value(errContextKey).(error)
+	return err
+}
@@ -11,8 +11,15 @@ import (
 	"github.com/klauspost/compress/s2"
 )
 
+// Unmarshal deserializes the bytes produced by Marshal.
+// The stages are traced by the default logger (level Debug).
 func Unmarshal(buf []byte) (TValues, error) {
-	printDebug("Unmarshal", buf)
+	return unmarshal(buf, log)
+}
+
+// unmarshal traces the stages with the logger of the Incorruptible (see WithLogger).
+func unmarshal(buf []byte, l *logger) (TValues, error) {
+	l.traceB("Unmarshal", buf)
 
 	if len(buf) < HeaderSize+ExpirySize {
 		return TValues{}, fmt.Errorf("not enough bytes (%d) for header+expiry", len(buf))
@@ -20,8 +27,7 @@ func Unmarshal(buf []byte) (TValues, error) {
 
 	meta := GetMetadata(buf)
 	buf = buf[HeaderSize:] // drop header
-
-	printDebug("Unmarshal Metadata", buf)
+	l.traceB("Unmarshal Metadata", buf)
 
 	if EnablePadding {
 		var err error
@@ -29,7 +35,7 @@ func Unmarshal(buf []byte) (TValues, error) {
 		if err != nil {
 			return TValues{}, err
 		}
-		printDebug("Unmarshal Padding", buf)
+		l.traceB("Unmarshal Padding", buf)
 	}
 
 	if meta.IsCompressed() {
@@ -38,7 +44,7 @@ func Unmarshal(buf []byte) (TValues, error) {
 		if err != nil {
 			return TValues{}, fmt.Errorf("s2.Decode %w", err)
 		}
-		printDebug("Unmarshal Uncompress", buf)
+		l.traceB("Unmarshal Uncompress", buf)
 	}
 
 	if len(buf) < meta.PayloadMinSize() {
@@ -48,16 +54,14 @@ func Unmarshal(buf []byte) (TValues, error) {
 	var tv TValues
 	buf, tv.Expires = DecodeExpiry(buf)
 	buf, tv.IP = meta.DecodeIP(buf)
-
-	printDebug("Unmarshal Expiry IP", buf)
+	l.traceB("Unmarshal Expiry IP", buf)
 
 	var err error
 	tv.Values, err = parseValues(buf, meta.NValues())
 	if err != nil {
 		return tv, err
 	}
-
-	printDebug("Unmarshal Values", buf)
+	l.traceB("Unmarshal Values", buf)
 
 	return tv, nil
 }
@@ -89,9 +93,3 @@ func parseValues(buf []byte, nV int) ([][]byte, error) {
 
 	return values, nil
 }
-
-func printDebug(name string, buf []byte) {
-	if doPrint {
-		log.Debugf("Incorr.%s len=%d", name, len(buf))
-	}
-}
@@ -0,0 +1,59 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+)
+
+// visitorIDSize is the number of random bytes identifying an anonymous visitor.
+// 8 bytes are enough for rate limiting and analytics, and keep the token compact.
+const visitorIDSize = 8
+
+// WithMinimalistToken enables the fast path used when maxAge <= 0 and setIP is false:
+// a single token is precomputed at startup, and all visitors receive the same cookie value.
+// This token conveys no per-visitor identity and is accepted without decryption.
+// Without this option, each visitor receives a distinct token.
+func WithMinimalistToken() Option {
+	return func(incorr *Incorruptible) {
+		incorr.minimalist = true
+	}
+}
+
+// WithVisitorID sets the key storing a random visitor ID minted by NewCookie
+// (and the Set middleware) for each new browser. The visitor ID is available
+// from the token values stored in the request context (see FromCtx and VisitorID).
+// WithVisitorID is incompatible with WithMinimalistToken.
+func WithVisitorID(key int) Option {
+	return func(incorr *Incorruptible) {
+		if err := checkWrite(key); err != nil {
+			log.Panic("WithVisitorID", err)
+		}
+		incorr.visitorKey = key
+	}
+}
+
+// VisitorID returns the visitor ID (hexadecimal) of the token values.
+func (incorr *Incorruptible) VisitorID(tv TValues) (string, bool) {
+	if incorr.visitorKey < 0 || incorr.visitorKey >= len(tv.Values) || len(tv.Values[incorr.visitorKey]) == 0 {
+		return "", false
+	}
+	return hex.EncodeToString(tv.Values[incorr.visitorKey]), true
+}
+
+// setVisitorID stores a new random visitor ID in the token values.
+func (incorr *Incorruptible) setVisitorID(tv *TValues) error {
+	if incorr.visitorKey < 0 {
+		return nil
+	}
+	id := make([]byte, visitorIDSize)
+	if _, err := crand.Read(id); err != nil {
+		return err
+	}
+	tv.set(incorr.visitorKey, id)
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func newSessionIncorr(t *testing.T, opts ...incorruptible.Option) *incorruptible.Incorruptible {
+	t.Helper()
+
+	u, err := url.Parse("https://example.com/")
+	if err != nil {
+		t.Fatal("url.Parse() error", err)
+	}
+
+	// maxAge=0 => session cookie without expiry
+	secretKey := []byte("1234567890" + "123456")
+	return incorruptible.New(nil, []*url.URL{u}, secretKey, "session", 0, false, opts...)
+}
+
+func TestVisitorID(t *testing.T) {
+	t.Parallel()
+
+	incorr := newSessionIncorr(t, incorruptible.WithVisitorID(0))
+
+	var ids []string
+	handler := incorr.Set(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tv, ok := incorruptible.FromCtx(r)
+		if !ok {
+			t.Fatal("FromCtx() found no token values")
+		}
+		id, ok := incorr.VisitorID(tv)
+		if !ok {
+			t.Fatal("VisitorID() found no visitor ID")
+		}
+		ids = append(ids, id)
+	}))
+
+	var cookies []*http.Cookie
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody))
+		cookies = append(cookies, w.Result().Cookies()...)
+	}
+
+	if len(cookies) != 2 || cookies[0].Value == cookies[1].Value {
+		t.Fatalf("each visitor must receive a distinct cookie, got %v", cookies)
+	}
+	if ids[0] == ids[1] {
+		t.Errorf("each visitor must receive a distinct ID, got %v", ids)
+	}
+
+	// the visitor keeps the same ID
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", http.NoBody)
+	r.AddCookie(cookies[0])
+	handler.ServeHTTP(w, r)
+	if ids[2] != ids[0] {
+		t.Errorf("visitor ID changed from %s to %s", ids[0], ids[2])
+	}
+	if len(w.Result().Cookies()) > 0 {
+		t.Error("Set() must not re-issue a valid cookie")
+	}
+}
+
+func TestMinimalistToken(t *testing.T) {
+	t.Parallel()
+
+	incorr := newSessionIncorr(t, incorruptible.WithMinimalistToken())
+
+	c1, _, err := incorr.NewCookie(nil)
+	if err != nil {
+		t.Fatal("NewCookie() error", err)
+	}
+	c2, _, err := incorr.NewCookie(nil)
+	if err != nil {
+		t.Fatal("NewCookie() error", err)
+	}
+	if c1.Value != c2.Value {
+		t.Error("the minimalist token must be shared")
+	}
+
+	without := newSessionIncorr(t)
+	c3, _, err := without.NewCookie(nil)
+	if err != nil {
+		t.Fatal("NewCookie() error", err)
+	}
+	c4, _, err := without.NewCookie(nil)
+	if err != nil {
+		t.Fatal("NewCookie() error", err)
+	}
+	if c3.Value == c4.Value {
+		t.Error("without WithMinimalistToken, the tokens must differ")
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ProblemTypeBase prefixes the Reason to form the "type" URI of the Problem Details.
+// The "type" URI of a rejection is stable: ProblemTypeBase + Reason (e.g. "…#expired_token").
+const ProblemTypeBase = "https://github.com/teal-finance/incorruptible#"
+
+// problem is the "application/problem+json" body (RFC 9457).
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Reason   Reason `json:"reason,omitempty"` // extension member
+}
+
+// WriteProblem is a WriteErr responding with Problem Details (RFC 9457).
+// When the first message conveys a Reason (see ReasonOf), the "type" URI is
+// ProblemTypeBase + Reason and the "title" is the Reason description:
+// the details about the rejected token are not disclosed.
+// Else, the "type" is "about:blank" and the first message is the "detail".
+// The other messages (key/value pairs) are not written.
+func WriteProblem(w http.ResponseWriter, r *http.Request, statusCode int, messages ...any) {
+	p := problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(statusCode),
+		Status: statusCode,
+	}
+
+	if len(messages) > 0 {
+		var reason Reason
+		if err, ok := messages[0].(error); ok && errors.As(err, &reason) {
+			p.Type = ProblemTypeBase + string(reason)
+			p.Title = reason.Description()
+			p.Reason = reason
+		} else {
+			p.Detail = fmt.Sprint(messages[0])
+		}
+	}
+
+	if r != nil {
+		p.Instance = r.URL.Path
+	}
+
+	buf, err := json.Marshal(p)
+	if err != nil {
+		buf = []byte(`{"type":"about:blank","status":` + strconv.Itoa(statusCode) + `}`)
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statusCode)
+
+	//nolint:errcheck // we do not care if write has failed
+	w.Write(buf)
+}
+
+// NegotiateWriteErr returns a WriteErr selecting the html writer
+// when the "Accept" header prefers "text/html" over JSON (browsers),
+// else the api writer. Both writers default to WriteProblem.
+//
+// Example:
+//
+//	writeErr := incorruptible.NegotiateWriteErr(writeErrorPage, nil)
+//	incorr := incorruptible.New(writeErr, urls, key, "session", 3600, true)
+//
+// See also WithLoginRedirect and RedirectToLogin redirecting the browsers to the login page.
+func NegotiateWriteErr(html, api WriteErr) WriteErr {
+	if html == nil {
+		html = WriteProblem
+	}
+	if api == nil {
+		api = WriteProblem
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, statusCode int, messages ...any) {
+		w.Header().Add("Vary", "Accept")
+		if r != nil && prefersHTML(r.Header.Get("Accept")) {
+			html(w, r, statusCode, messages...)
+		} else {
+			api(w, r, statusCode, messages...)
+		}
+	}
+}
+
+// prefersHTML returns true when the Accept header gives a higher quality
+// to "text/html" than to the JSON media types.
+func prefersHTML(accept string) bool {
+	ranges := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, q := parseAccept(part)
+		if prev, ok := ranges[mediaType]; !ok || q > prev {
+			ranges[mediaType] = q
+		}
+	}
+
+	qHTML := quality(ranges, "text/html")
+	qJSON := max(quality(ranges, "application/json"), quality(ranges, "application/problem+json"))
+	return qHTML > qJSON
+}
+
+// quality returns the quality of the most specific media range matching the media type
+// (RFC 9110 §12.5.1): "text/html" takes precedence over "text/*" that takes precedence over "*/*".
+func quality(ranges map[string]float64, mediaType string) float64 {
+	if q, ok := ranges[mediaType]; ok {
+		return q
+	}
+	typ, _, _ := strings.Cut(mediaType, "/")
+	if q, ok := ranges[typ+"/*"]; ok {
+		return q
+	}
+	return ranges["*/*"] // zero when absent
+}
+
+// parseAccept returns the media type and the quality of an element of the Accept header.
+func parseAccept(part string) (string, float64) {
+	mediaType, params, _ := strings.Cut(part, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	q := 1.0
+	for _, p := range strings.Split(params, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if ok && strings.TrimSpace(k) == "q" {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil && f >= 0 && f <= 1 {
+				q = f
+			}
+		}
+	}
+	return mediaType, q
+}
@@ -0,0 +1,107 @@
+// Copyright 2022 Teal.Finance/incorruptible contributors
+// This file is part of Teal.Finance/incorruptible
+// a tiny+secured cookie token licensed under the MIT License.
+// SPDX-License-Identifier: MIT
+
+package incorruptible_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/teal-finance/incorruptible"
+)
+
+func TestWriteProblem(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		status   int
+		messages []any
+		typ      string
+		detail   string
+	}{
+		{
+			"reason", http.StatusUnauthorized,
+			[]any{fmt.Errorf("%w: secret details", incorruptible.ReasonExpired), "error_cookie", "more details"},
+			incorruptible.ProblemTypeBase + "expired_token", "",
+		},
+		{"plain error", http.StatusForbidden, []any{errors.New("cross-site")}, "about:blank", "cross-site"},
+		{"string", http.StatusTooManyRequests, []any{"Too many requests"}, "about:blank", "Too many requests"},
+		{"no message", http.StatusUnauthorized, nil, "about:blank", ""},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/api?x=1", http.NoBody)
+		w := httptest.NewRecorder()
+		incorruptible.WriteProblem(w, r, c.status, c.messages...)
+
+		if w.Code != c.status {
+			t.Errorf("%s: status %d, want %d", c.name, w.Code, c.status)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("%s: Content-Type %q", c.name, ct)
+		}
+
+		var p map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatalf("%s: json.Unmarshal() error %v body=%s", c.name, err, w.Body)
+		}
+		if p["type"] != c.typ || p["status"] != float64(c.status) || p["instance"] != "/api" {
+			t.Errorf("%s: unexpected problem %v", c.name, p)
+		}
+		if d, _ := p["detail"].(string); d != c.detail {
+			t.Errorf("%s: detail %q, want %q", c.name, d, c.detail)
+		}
+	}
+}
+
+func TestNegotiateWriteErr(t *testing.T) {
+	t.Parallel()
+
+	html := func(w http.ResponseWriter, r *http.Request, _ int, _ ...any) {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	}
+	writeErr := incorruptible.NegotiateWriteErr(html, nil)
+	incorr := incorruptible.New(writeErr, []*url.URL{{Scheme: "https", Host: "example.com"}},
+		[]byte("1234567890123456"), "session", 3600, false)
+	handler := incorr.Chk(okHandler)
+
+	cases := []struct {
+		accept string
+		status int
+	}{
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", http.StatusSeeOther},
+		{"application/json", http.StatusUnauthorized},
+		{"*/*", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+		{"application/json;q=0.5, text/html", http.StatusSeeOther},
+		{"text/html;q=0.1, application/problem+json", http.StatusUnauthorized},
+		{"text/*, application/*;q=0.5", http.StatusSeeOther},
+		{"text/html;q=0, */*", http.StatusUnauthorized},
+		{"text/*;q=0.2, */*", http.StatusUnauthorized},
+		{"application/json;q=0, application/problem+json;q=0, */*;q=0.5", http.StatusSeeOther},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/page", http.NoBody)
+		if c.accept != "" {
+			r.Header.Set("Accept", c.accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != c.status {
+			t.Errorf("Accept %q: status %d, want %d", c.accept, w.Code, c.status)
+		}
+		if w.Header().Get("Vary") != "Accept" {
+			t.Errorf("Accept %q: missing Vary header", c.accept)
+		}
+	}
+}